@@ -3,21 +3,45 @@ package artifact
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Manifest describes the output of a build task.
 type Manifest struct {
-	Project     string `json:"project"`
-	Kind        string `json:"kind"`
-	Toolchain   string `json:"toolchain"`
-	Version     string `json:"version"`
-	Hash        string `json:"hash"`
-	BuildTimeMs int64  `json:"buildTimeMs"`
-	Reused      bool   `json:"reused"`
-	CreatedAt   string `json:"createdAt"`
+	Project   string `json:"project"`
+	Kind      string `json:"kind"`
+	Toolchain string `json:"toolchain"`
+	Version   string `json:"version"`
+	Variant   string `json:"variant,omitempty"`
+	Hash      string `json:"hash"`
+	// Image is the build image, pinned to ImageDigest for container builds.
+	Image       string       `json:"image,omitempty"`
+	ImageDigest string       `json:"imageDigest,omitempty"`
+	BuildTimeMs int64        `json:"buildTimeMs"`
+	Reused      bool         `json:"reused"`
+	Artifacts   []string     `json:"artifacts,omitempty"`
+	Steps       []Step       `json:"steps,omitempty"`
+	Tests       *TestSummary `json:"tests,omitempty"`
+	Retries     []Retry      `json:"retries,omitempty"`
+	CreatedAt   string       `json:"createdAt"`
+}
+
+// Retry records a failed attempt that was run again.
+type Retry struct {
+	Attempt    int    `json:"attempt"`
+	Error      string `json:"error"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+// Step records one build step: a hook, dependency install or build script.
+type Step struct {
+	Name       string `json:"name"`
+	Command    string `json:"command"`
+	DurationMs int64  `json:"durationMs"`
 }
 
 // WriteManifest writes a manifest.json into the given output directory.
@@ -36,3 +60,114 @@ func WriteManifest(outDir string, m Manifest) error {
 	}
 	return nil
 }
+
+// Collect copies build outputs from srcDir into outDir. Each entry in dirs is
+// a path or glob pattern relative to srcDir; entries that match nothing are
+// skipped. It returns the paths that were copied, in the order given.
+func Collect(srcDir, outDir string, dirs []string) ([]string, error) {
+	var collected []string
+	for _, d := range dirs {
+		if d == "" {
+			continue
+		}
+		if err := validatePath(d); err != nil {
+			return collected, err
+		}
+		matches, err := filepath.Glob(filepath.Join(srcDir, d))
+		if err != nil {
+			return collected, fmt.Errorf("collect %s: %w", d, err)
+		}
+		for _, src := range matches {
+			info, err := os.Stat(src)
+			if err != nil {
+				continue
+			}
+			dest := filepath.Join(outDir, filepath.Base(src))
+			if info.IsDir() {
+				err = copyDir(src, dest)
+			} else {
+				err = copyFile(src, dest)
+			}
+			if err != nil {
+				return collected, fmt.Errorf("collect %s: %w", d, err)
+			}
+			rel, err := filepath.Rel(srcDir, src)
+			if err != nil {
+				return collected, err
+			}
+			collected = append(collected, filepath.ToSlash(rel))
+		}
+	}
+	return collected, nil
+}
+
+// validatePath ensures the path doesn't contain path traversal attempts
+func validatePath(path string) error {
+	cleanPath := filepath.Clean(path)
+	if strings.Contains(cleanPath, "..") || filepath.IsAbs(cleanPath) {
+		return fmt.Errorf("invalid path: path traversal detected in %s", path)
+	}
+	return nil
+}
+
+// copyDir recursively copies a directory
+func copyDir(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		destPath := filepath.Join(dest, relPath)
+		if info.IsDir() {
+			return os.MkdirAll(destPath, 0o750)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(path, destPath)
+	})
+}
+
+// copyFile copies a single file, preserving its permission bits
+func copyFile(src, dest string) error {
+	// #nosec G304 - Source comes from a validated artifact directory
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	info, err := srcFile.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return err
+	}
+	// #nosec G304 - Destination is under the output directory
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, srcFile)
+	return err
+}
+
+// ReadManifest loads manifest.json from the given output directory.
+func ReadManifest(outDir string) (Manifest, error) {
+	var m Manifest
+	// #nosec G304 - Reads from the tool's own output directory
+	data, err := os.ReadFile(filepath.Join(outDir, "manifest.json"))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("parse manifest: %w", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,139 @@
+package artifact
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TestResultsDir is the directory, next to manifest.json, that test result
+// files are copied into.
+const TestResultsDir = "test-results"
+
+// TestSummary counts the outcome of a task's tests.
+type TestSummary struct {
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+	Files   []string `json:"files,omitempty"`
+}
+
+func (s TestSummary) String() string {
+	return fmt.Sprintf("%d failed, %d passed, %d skipped", s.Failed, s.Passed, s.Skipped)
+}
+
+// CollectTestResults copies the JUnit XML and TRX files matching patterns
+// (relative to srcDir) that were written at or after since into
+// outDir/test-results, and totals their counts. It returns nil when no
+// result files were found.
+func CollectTestResults(srcDir, outDir string, patterns []string, since time.Time) (*TestSummary, error) {
+	var summary *TestSummary
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		if err := validatePath(pattern); err != nil {
+			return summary, err
+		}
+		matches, err := filepath.Glob(filepath.Join(srcDir, pattern))
+		if err != nil {
+			return summary, fmt.Errorf("collect test results %s: %w", pattern, err)
+		}
+		for _, src := range matches {
+			info, err := os.Stat(src)
+			// Older files are left over from a previous run
+			if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(since) || seen[src] {
+				continue
+			}
+			seen[src] = true
+
+			counts, err := parseTestResults(src)
+			if err != nil {
+				return summary, fmt.Errorf("parse test results %s: %w", filepath.Base(src), err)
+			}
+			name := filepath.Base(src)
+			if err := copyFile(src, filepath.Join(outDir, TestResultsDir, name)); err != nil {
+				return summary, fmt.Errorf("collect test results: %w", err)
+			}
+			if summary == nil {
+				summary = &TestSummary{}
+			}
+			summary.Passed += counts.Passed
+			summary.Failed += counts.Failed
+			summary.Skipped += counts.Skipped
+			summary.Files = append(summary.Files, filepath.ToSlash(filepath.Join(TestResultsDir, name)))
+		}
+	}
+	return summary, nil
+}
+
+// parseTestResults counts the results in a JUnit XML or TRX file.
+func parseTestResults(path string) (TestSummary, error) {
+	// #nosec G304 - Reads result files matched inside the project directory
+	f, err := os.Open(path)
+	if err != nil {
+		return TestSummary{}, err
+	}
+	defer f.Close()
+
+	var s TestSummary
+	dec := xml.NewDecoder(f)
+	// Outcome of the <testcase> being read: passed until a child says otherwise
+	inCase, outcome := false, ""
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return s, err
+		}
+		switch el := tok.(type) {
+		case xml.StartElement:
+			switch el.Name.Local {
+			case "testcase":
+				inCase, outcome = true, "passed"
+			case "failure", "error":
+				if inCase {
+					outcome = "failed"
+				}
+			case "skipped":
+				if inCase && outcome == "passed" {
+					outcome = "skipped"
+				}
+			case "Counters":
+				// TRX files summarise their results in a single element
+				return trxCounters(el), nil
+			}
+		case xml.EndElement:
+			if el.Name.Local == "testcase" && inCase {
+				switch outcome {
+				case "failed":
+					s.Failed++
+				case "skipped":
+					s.Skipped++
+				default:
+					s.Passed++
+				}
+				inCase = false
+			}
+		}
+	}
+	return s, nil
+}
+
+func trxCounters(el xml.StartElement) TestSummary {
+	n := map[string]int{}
+	for _, a := range el.Attr {
+		n[strings.ToLower(a.Name.Local)], _ = strconv.Atoi(a.Value)
+	}
+	return TestSummary{
+		Passed:  n["passed"],
+		Failed:  n["failed"] + n["error"] + n["timeout"] + n["aborted"],
+		Skipped: n["notexecuted"] + n["inconclusive"],
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/toolchain"
 	"sort"
 	"strings"
 )
@@ -24,13 +26,15 @@ func validatePath(path string) error {
 	return nil
 }
 
-// Key generates a cache key based on the task and environment
-func Key(task planner.Task, workspaceRoot string) (string, error) {
+// Key generates a cache key based on the task and environment. Extra inputs
+// (such as the effective build command) are mixed into the hash in order.
+func Key(task planner.Task, workspaceRoot string, extra ...string) (string, error) {
 	h := sha256.New()
 	
 	// Include toolchain and version
 	h.Write([]byte(task.Kind))
 	h.Write([]byte(task.Version))
+	h.Write([]byte(task.Variant))
 	
 	// Include project path
 	h.Write([]byte(task.Path))
@@ -52,37 +56,84 @@ func Key(task planner.Task, workspaceRoot string) (string, error) {
 		}
 	}
 	
+	// Include every source file so edits invalidate the cache
+	if err := hashSources(h, projectDir); err != nil {
+		return "", fmt.Errorf("hash sources: %w", err)
+	}
+	
+	for _, e := range extra {
+		h.Write([]byte{0})
+		h.Write([]byte(e))
+	}
+	
 	return fmt.Sprintf("%x", h.Sum(nil))[:12], nil
 }
 
-// findLockFiles returns relevant lock files for the given project type
-func findLockFiles(projectDir, kind string) []string {
-	var lockFiles []string
-	
-	switch kind {
-	case "dotnet":
-		// Check for project files and package lock files
-		patterns := []string{"*.csproj", "*.fsproj", "*.vbproj", "packages.lock.json"}
-		for _, pattern := range patterns {
-			matches, _ := filepath.Glob(filepath.Join(projectDir, pattern))
-			lockFiles = append(lockFiles, matches...)
+// hashSources writes the relative path and content of every regular file
+// under projectDir into h, in the order walkSources visits them.
+func hashSources(h io.Writer, projectDir string) error {
+	return walkSources(projectDir, func(rel string, d fs.DirEntry) error {
+		if !d.Type().IsRegular() {
+			return nil
 		}
-	case "node":
-		// Check for package.json and lock files
-		candidates := []string{
-			filepath.Join(projectDir, "package.json"),
-			filepath.Join(projectDir, "package-lock.json"),
-			filepath.Join(projectDir, "yarn.lock"),
-			filepath.Join(projectDir, "pnpm-lock.yaml"),
+		// #nosec G304 - Walking files inside the project directory
+		f, err := os.Open(filepath.Join(projectDir, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
 		}
-		for _, file := range candidates {
-			if _, err := os.Stat(file); err == nil {
-				lockFiles = append(lockFiles, file)
+		defer f.Close()
+		fh := sha256.New()
+		if _, err := io.Copy(fh, f); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(h, "%s\x00%x\n", rel, fh.Sum(nil))
+		return err
+	})
+}
+
+// walkSources calls fn with the slash-separated relative path of every file
+// and symlink under projectDir that is part of the project's sources, in
+// lexical order, skipping anything matched by DefaultIgnore or a
+// .gitignore/.buildignore in the tree. A missing project directory has no
+// sources.
+func walkSources(projectDir string, fn func(rel string, d fs.DirEntry) error) error {
+	if _, err := os.Stat(projectDir); os.IsNotExist(err) {
+		return nil
+	}
+	ignore := newIgnoreList(DefaultIgnore)
+	return filepath.WalkDir(projectDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(projectDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && ignore.match(rel, true) {
+				return filepath.SkipDir
+			}
+			if rel == "." {
+				rel = ""
 			}
+			ignore.load(p, rel)
+			return nil
 		}
+		if ignore.match(rel, false) {
+			return nil
+		}
+		return fn(rel, d)
+	})
+}
+
+// findLockFiles returns relevant lock files for the given project type
+func findLockFiles(projectDir, kind string) []string {
+	tc, ok := toolchain.Lookup(kind)
+	if !ok {
+		return nil
 	}
-	
-	return lockFiles
+	return tc.LockFiles(projectDir)
 }
 
 // Exists checks if a cache entry exists for the given key
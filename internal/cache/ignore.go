@@ -0,0 +1,128 @@
+package cache
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"slick-autobuild/internal/ignore"
+)
+
+// IgnoreFiles are the per-directory exclude lists honoured when hashing
+// project sources. Both use .gitignore syntax.
+var IgnoreFiles = []string{".gitignore", ".buildignore"}
+
+// DefaultIgnore lists paths that are never part of a project's source hash:
+// VCS metadata and dependency folders at any depth, and the usual build
+// output and test result folders at the project root only, so a source
+// directory such as src/build still counts.
+var DefaultIgnore = []string{
+	".git/",
+	"node_modules/",
+	".buildcache/",
+	"/bin/",
+	"/obj/",
+	"/dist/",
+	"/build/",
+	"/target/",
+	"/test-results/",
+}
+
+// ignoreRule is a single parsed .gitignore-style pattern.
+type ignoreRule struct {
+	base    string // directory (relative to the project) the rule was declared in
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+	nested  bool // pattern contains a slash and matches against the full relative path
+}
+
+// ignoreList evaluates rules in declaration order; the last match wins.
+type ignoreList struct {
+	rules []ignoreRule
+}
+
+func newIgnoreList(patterns []string) *ignoreList {
+	l := &ignoreList{}
+	for _, p := range patterns {
+		l.add("", p)
+	}
+	return l
+}
+
+// load reads the ignore files found in dir, whose path relative to the
+// project root is rel.
+func (l *ignoreList) load(dir, rel string) {
+	for _, name := range IgnoreFiles {
+		// #nosec G304 - Reads well-known ignore files inside the project directory
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			l.add(rel, sc.Text())
+		}
+		f.Close()
+	}
+}
+
+func (l *ignoreList) add(base, line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	r := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		r.nested = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return
+	}
+	re, err := regexp.Compile("^" + ignore.GlobToRegexp(line) + "$")
+	if err != nil {
+		return
+	}
+	r.re = re
+	l.rules = append(l.rules, r)
+}
+
+// match reports whether rel (slash-separated, relative to the project root)
+// is excluded.
+func (l *ignoreList) match(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range l.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			sub = strings.TrimPrefix(rel, r.base+"/")
+		}
+		target := sub
+		if !r.nested {
+			target = path.Base(sub)
+		}
+		if r.re.MatchString(target) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
@@ -2,11 +2,11 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-
-	"gopkg.in/yaml.v3"
 )
 
 // Root is the top-level configuration structure for the build tool.
@@ -14,11 +14,47 @@ type Root struct {
 	Runtime RuntimeConfig   `yaml:"runtime"`
 	Matrix  []MatrixEntry   `yaml:"matrix"`
 	Defaults DefaultSection `yaml:"defaults"`
+	Images  ImagesConfig    `yaml:"images"`
+}
+
+// ImagesConfig controls where build images come from.
+type ImagesConfig struct {
+	// Overrides maps a toolchain kind to the image it builds with, such as
+	// an internal mirror; "{version}" is replaced by the toolchain version.
+	Overrides map[string]string `yaml:"overrides,omitempty"`
+	// AllowedRegistries, when set, lists the only registries build images
+	// may come from. Images without a registry come from docker.io.
+	AllowedRegistries []string `yaml:"allowedRegistries,omitempty"`
 }
 
 type RuntimeConfig struct {
 	Dotnet VersionSet `yaml:"dotnet"`
 	Node   VersionSet `yaml:"node"`
+	Go     VersionSet `yaml:"go"`
+	Python VersionSet `yaml:"python"`
+	Java   VersionSet `yaml:"java"` // JDK versions
+	Rust   VersionSet `yaml:"rust"`
+	// Other holds version sets for registered toolchains without a field above.
+	Other map[string]VersionSet `yaml:",inline"`
+}
+
+// Versions returns the runtime version set for a toolchain kind.
+func (rc RuntimeConfig) Versions(kind string) []string {
+	switch kind {
+	case "dotnet":
+		return rc.Dotnet.Versions
+	case "node":
+		return rc.Node.Versions
+	case "go":
+		return rc.Go.Versions
+	case "python":
+		return rc.Python.Versions
+	case "java":
+		return rc.Java.Versions
+	case "rust":
+		return rc.Rust.Versions
+	}
+	return rc.Other[kind].Versions
 }
 
 type VersionSet struct {
@@ -30,11 +66,113 @@ type MatrixEntry struct {
 	Type          string   `yaml:"type"`
 	Frameworks    []string `yaml:"frameworks"` // dotnet specific (SDK versions override)
 	NodeVersions  []string `yaml:"nodeVersions"`
+	GoVersions    []string `yaml:"goVersions,omitempty"`
+	PythonVersions []string `yaml:"pythonVersions,omitempty"`
+	JavaVersions  []string `yaml:"javaVersions,omitempty"` // JDK versions
+	RustVersions  []string `yaml:"rustVersions,omitempty"`
+	Versions      []string `yaml:"versions,omitempty"` // toolchain versions for kinds without a dedicated field
 	PackageManager string  `yaml:"packageManager"`
 	BuildScripts  []string `yaml:"buildScripts"`
+	ArtifactDir   string   `yaml:"artifactDir,omitempty"` // overrides the toolchain's default output dir
+	DependsOn     []string `yaml:"dependsOn,omitempty"`   // paths of matrix entries that must build first
+	PreBuild      []string `yaml:"preBuild,omitempty"`    // shell hooks run before the build
+	PostBuild     []string `yaml:"postBuild,omitempty"`   // shell hooks run after the build
+	AllowScripts  bool     `yaml:"allowScripts,omitempty"` // opt in to running preBuild/postBuild hooks
+	Timeout       string   `yaml:"timeout,omitempty"`      // per-attempt limit, e.g. 20m; overrides defaults.timeout
+	Retries       *int     `yaml:"retries,omitempty"`      // extra attempts after a failure; overrides defaults.retries
+	Env           map[string]string `yaml:"env,omitempty"`   // build variables, merged over defaults.env
+	Secrets       []SecretConfig    `yaml:"secrets,omitempty"` // merged with defaults.secrets by name
+	DepCache      *DepCacheConfig   `yaml:"depCache,omitempty"` // overrides defaults.depCache
+	RunAsRoot     bool              `yaml:"runAsRoot,omitempty"` // build as the image's user instead of the invoking user
+	Sandbox       *SandboxConfig    `yaml:"sandbox,omitempty"` // overrides defaults.sandbox
+	Dotnet        *DotnetConfig `yaml:"dotnet,omitempty"`
+	Test          *TestConfig   `yaml:"test,omitempty"`
 	Docker        *DockerConfig `yaml:"docker,omitempty"`
 }
 
+// TestConfig enables the test stage, which runs after the build in the same
+// container.
+type TestConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Command overrides the toolchain's test command.
+	Command string `yaml:"command,omitempty"`
+	// Results are JUnit XML or TRX file globs relative to the project; they
+	// default to where the toolchain's test command writes them.
+	Results []string `yaml:"results,omitempty"`
+}
+
+// DotnetConfig holds the .NET build settings of a matrix entry.
+type DotnetConfig struct {
+	// Mode is build (default), publish or pack.
+	Mode string `yaml:"mode,omitempty"`
+	// Configuration defaults to Release.
+	Configuration string `yaml:"configuration,omitempty"`
+	// RuntimeIdentifiers adds a matrix axis: each SDK version builds once per RID.
+	RuntimeIdentifiers []string `yaml:"runtimeIdentifiers,omitempty"`
+	SelfContained      *bool    `yaml:"selfContained,omitempty"`
+	// Project or Solution selects the file to build when the directory has
+	// several; both are relative to the entry path.
+	Project  string `yaml:"project,omitempty"`
+	Solution string `yaml:"solution,omitempty"`
+	// Properties are passed to MSBuild as -p:Name=Value.
+	Properties map[string]string `yaml:"properties,omitempty"`
+}
+
+// DepCacheConfig controls the package-manager caches (npm, pnpm, yarn,
+// NuGet, cargo ...) kept between build container runs.
+type DepCacheConfig struct {
+	// Mode is shared (default): one cache per toolchain and version;
+	// isolated: one per project as well; or off: every build starts cold.
+	Mode string `yaml:"mode,omitempty"`
+	// Dir keeps the caches in host directories under Dir instead of named
+	// volumes.
+	Dir string `yaml:"dir,omitempty"`
+}
+
+// SandboxConfig limits what a build container can reach and use.
+type SandboxConfig struct {
+	// Network is default, or none: only the dependency install steps can
+	// reach the network and the rest of the build runs offline.
+	Network string `yaml:"network,omitempty"`
+	// CPUs, Memory (bytes, or with a k, m, g or t suffix such as "4g") and
+	// Pids cap the container. Unset limits scale with --concurrency; 0
+	// removes a limit.
+	CPUs   *float64 `yaml:"cpus,omitempty"`
+	Memory string   `yaml:"memory,omitempty"`
+	Pids   *int64   `yaml:"pids,omitempty"`
+}
+
+// ParseSize parses a byte count such as "512m" or "4g"; suffixes are
+// powers of 1024 as with docker run --memory.
+func ParseSize(s string) (int64, error) {
+	num := strings.ToLower(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "b")
+	shift := 0
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("kmgt", num[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v < 0 || v > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %q (expected bytes or a number with a k, m, g or t suffix)", s)
+	}
+	return v << shift, nil
+}
+
+// SecretConfig passes a host value to builds as an environment variable.
+// Unlike env, secrets are left out of cache keys and masked in the logs.
+type SecretConfig struct {
+	// Name is the variable the build sees.
+	Name string `yaml:"name"`
+	// Env is the host variable holding the value; it defaults to Name.
+	Env string `yaml:"env,omitempty"`
+	// File reads the value from a host file instead, mounted read-only at
+	// /run/secrets/<name>; the variable holds the file's path.
+	File string `yaml:"file,omitempty"`
+}
+
 type DockerConfig struct {
 	Enabled    bool     `yaml:"enabled"`
 	Repository string   `yaml:"repository"`
@@ -47,6 +185,30 @@ type DockerConfig struct {
 type DefaultSection struct {
 	Concurrency int    `yaml:"concurrency"`
 	ArtifactDir string `yaml:"artifactDir"`
+	// Engine is the container CLI: docker (default), podman or nerdctl.
+	Engine string `yaml:"engine"`
+	// AllowScripts opts every matrix entry in to preBuild/postBuild hooks.
+	AllowScripts bool `yaml:"allowScripts"`
+	// Timeout limits each attempt of a task, e.g. "30m"; empty means none.
+	Timeout string `yaml:"timeout"`
+	// Retries is how many times a failed task is run again.
+	Retries int `yaml:"retries"`
+	// RetryBackoff is the wait before the first retry, doubling after each
+	// attempt; it defaults to 10s.
+	RetryBackoff string `yaml:"retryBackoff"`
+	// Env holds variables set in every build; "${VAR}" expands from the
+	// host environment.
+	Env map[string]string `yaml:"env"`
+	// Secrets are passed to every build.
+	Secrets []SecretConfig `yaml:"secrets"`
+	// DepCache controls the package-manager caches of container builds.
+	DepCache DepCacheConfig `yaml:"depCache"`
+	// RunAsRoot runs every build container as the image's user (usually
+	// root) instead of the invoking user.
+	RunAsRoot bool `yaml:"runAsRoot"`
+	// Sandbox sets the network access and resource limits of container
+	// builds.
+	Sandbox SandboxConfig `yaml:"sandbox"`
 }
 
 // validatePath ensures the path is safe and doesn't contain path traversal attempts
@@ -62,7 +224,8 @@ func validatePath(path string) error {
 	return nil
 }
 
-// Load reads a YAML config file.
+// Load reads and validates a YAML config file. Schema problems are
+// returned as a *ValidationError.
 func Load(path string) (*Root, error) {
 	// Validate the config file path
 	if err := validatePath(path); err != nil {
@@ -74,9 +237,5 @@ func Load(path string) (*Root, error) {
 	if err != nil {
 		return nil, err
 	}
-	var r Root
-	if err := yaml.Unmarshal(data, &r); err != nil {
-		return nil, fmt.Errorf("parse yaml: %w", err)
-	}
-	return &r, nil
+	return parse(path, data)
 }
@@ -0,0 +1,229 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// generatedHeader is written at the top of configs created by Generate.
+const generatedHeader = `Generated by "slick-autobuild init". Edit freely: "init --merge" only
+adds projects it has not seen before and never rewrites existing entries.`
+
+// Generate renders a commented build.yaml for the given runtime versions
+// and matrix. notes maps a matrix path to a comment placed above its entry.
+func Generate(rt RuntimeConfig, matrix []MatrixEntry, notes map[string]string) ([]byte, error) {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, HeadComment: generatedHeader, Content: []*yaml.Node{root}}
+
+	runtimeNode := &yaml.Node{Kind: yaml.MappingNode}
+	for _, kv := range runtimeSets(rt) {
+		if len(kv.set.Versions) > 0 {
+			appendPair(runtimeNode, kv.name, versionSetNode(kv.set))
+		}
+	}
+	appendPair(root, "runtime", runtimeNode)
+	root.Content[0].HeadComment = "Toolchain versions every matrix entry builds against unless it overrides them."
+
+	matrixNode := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, m := range matrix {
+		n := entryNode(m)
+		n.HeadComment = notes[m.Path]
+		matrixNode.Content = append(matrixNode.Content, n)
+	}
+	appendPair(root, "matrix", matrixNode)
+	root.Content[2].HeadComment = "One entry per project. See README.md for per-entry options such as dependsOn and docker."
+
+	appendPair(root, "defaults", &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+		scalar("concurrency"), {Kind: yaml.ScalarNode, Tag: "!!int", Value: "4"},
+	}})
+
+	return encode(doc)
+}
+
+// Merge adds matrix entries whose path is not yet listed in the config file
+// at path, and runtime version sets for toolchains it does not mention.
+// Everything already in the file is kept as written. It returns the paths
+// that were added.
+func Merge(path string, rt RuntimeConfig, matrix []MatrixEntry, notes map[string]string) ([]string, error) {
+	if err := validatePath(path); err != nil {
+		return nil, fmt.Errorf("invalid config path: %w", err)
+	}
+	// #nosec G304 - Path is validated above to prevent traversal attacks
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("config error: %s: top level must be a mapping", path)
+	}
+
+	runtimeNode := lookup(root, "runtime")
+	if runtimeNode == nil {
+		runtimeNode = &yaml.Node{Kind: yaml.MappingNode}
+		appendPair(root, "runtime", runtimeNode)
+	}
+	for _, kv := range runtimeSets(rt) {
+		if len(kv.set.Versions) > 0 && lookup(runtimeNode, kv.name) == nil {
+			appendPair(runtimeNode, kv.name, versionSetNode(kv.set))
+		}
+	}
+
+	matrixNode := lookup(root, "matrix")
+	if matrixNode == nil {
+		matrixNode = &yaml.Node{Kind: yaml.SequenceNode}
+		appendPair(root, "matrix", matrixNode)
+	}
+	existing := map[string]bool{}
+	for _, n := range matrixNode.Content {
+		if p := lookup(n, "path"); p != nil {
+			existing[p.Value] = true
+		}
+	}
+	var added []string
+	for _, m := range matrix {
+		if existing[m.Path] {
+			continue
+		}
+		n := entryNode(m)
+		n.HeadComment = notes[m.Path]
+		matrixNode.Content = append(matrixNode.Content, n)
+		added = append(added, m.Path)
+	}
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	out, err := encode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, out, 0o600); err != nil {
+		return nil, fmt.Errorf("write config: %w", err)
+	}
+	return added, nil
+}
+
+type namedSet struct {
+	name string
+	set  VersionSet
+}
+
+// runtimeSets lists the runtime version sets in file order.
+func runtimeSets(rt RuntimeConfig) []namedSet {
+	sets := []namedSet{
+		{"dotnet", rt.Dotnet},
+		{"node", rt.Node},
+		{"go", rt.Go},
+		{"python", rt.Python},
+		{"java", rt.Java},
+		{"rust", rt.Rust},
+	}
+	var other []string
+	for kind := range rt.Other {
+		other = append(other, kind)
+	}
+	sort.Strings(other)
+	for _, kind := range other {
+		sets = append(sets, namedSet{kind, rt.Other[kind]})
+	}
+	return sets
+}
+
+// entryNode renders a matrix entry, omitting empty fields.
+func entryNode(m MatrixEntry) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	appendPair(n, "path", scalar(m.Path))
+	if m.Type != "" {
+		appendPair(n, "type", scalar(m.Type))
+	}
+	if len(m.Frameworks) > 0 {
+		appendPair(n, "frameworks", listNode(m.Frameworks))
+	}
+	if len(m.NodeVersions) > 0 {
+		appendPair(n, "nodeVersions", listNode(m.NodeVersions))
+	}
+	if len(m.GoVersions) > 0 {
+		appendPair(n, "goVersions", listNode(m.GoVersions))
+	}
+	if len(m.PythonVersions) > 0 {
+		appendPair(n, "pythonVersions", listNode(m.PythonVersions))
+	}
+	if len(m.JavaVersions) > 0 {
+		appendPair(n, "javaVersions", listNode(m.JavaVersions))
+	}
+	if len(m.RustVersions) > 0 {
+		appendPair(n, "rustVersions", listNode(m.RustVersions))
+	}
+	if len(m.Versions) > 0 {
+		appendPair(n, "versions", listNode(m.Versions))
+	}
+	if m.PackageManager != "" {
+		appendPair(n, "packageManager", scalar(m.PackageManager))
+	}
+	if len(m.BuildScripts) > 0 {
+		appendPair(n, "buildScripts", listNode(m.BuildScripts))
+	}
+	if m.ArtifactDir != "" {
+		appendPair(n, "artifactDir", scalar(m.ArtifactDir))
+	}
+	if len(m.DependsOn) > 0 {
+		appendPair(n, "dependsOn", listNode(m.DependsOn))
+	}
+	return n
+}
+
+func versionSetNode(vs VersionSet) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.MappingNode}
+	appendPair(n, "versions", listNode(vs.Versions))
+	return n
+}
+
+func listNode(items []string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, item := range items {
+		n.Content = append(n.Content, scalar(item))
+	}
+	return n
+}
+
+// scalar returns a string node; values that YAML would read as another type
+// (such as the version 8.0) are quoted.
+func scalar(v string) *yaml.Node {
+	n := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}
+	var probe interface{}
+	if err := yaml.Unmarshal([]byte(v), &probe); err != nil {
+		n.Style = yaml.DoubleQuotedStyle
+	} else if _, ok := probe.(string); !ok {
+		n.Style = yaml.DoubleQuotedStyle
+	}
+	return n
+}
+
+func appendPair(m *yaml.Node, key string, val *yaml.Node) {
+	m.Content = append(m.Content, scalar(key), val)
+}
+
+func encode(n *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(n); err != nil {
+		return nil, fmt.Errorf("encode yaml: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
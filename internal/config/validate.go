@@ -0,0 +1,649 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"slick-autobuild/internal/engine"
+)
+
+// knownTypes lists the matrix entry types the planner can expand. The
+// toolchain registry adds to it through RegisterType.
+var (
+	knownTypesMu sync.RWMutex
+	knownTypes   []string
+)
+
+// RegisterType marks a matrix entry type as valid.
+func RegisterType(kind string) {
+	knownTypesMu.Lock()
+	defer knownTypesMu.Unlock()
+	if !contains(knownTypes, kind) {
+		knownTypes = append(knownTypes, kind)
+	}
+}
+
+// types returns the registered matrix entry types.
+func types() []string {
+	knownTypesMu.RLock()
+	defer knownTypesMu.RUnlock()
+	return append([]string(nil), knownTypes...)
+}
+
+// repositoryRegex matches a Docker repository name with an optional
+// registry host (and port) prefix, e.g. ghcr.io/org/app.
+var repositoryRegex = regexp.MustCompile(`^(?:[a-zA-Z0-9.-]+(?::[0-9]+)?/)?[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+// tagRegex matches a Docker image tag.
+var tagRegex = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// Problem is a single validation finding with its position in the file.
+type Problem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Message)
+}
+
+// ValidationError reports every problem found in a config file.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("config error: %d problem(s)", len(e.Problems)))
+	for _, p := range e.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate reads the config file at path and checks it against the schema.
+// It returns a *ValidationError listing every problem, or nil.
+func Validate(path string) error {
+	if err := validatePath(path); err != nil {
+		return fmt.Errorf("invalid config path: %w", err)
+	}
+	// #nosec G304 - Path is validated above to prevent traversal attacks
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ValidateBytes(path, data)
+}
+
+// ValidateBytes checks config data against the schema, reporting problems
+// against the file name. It returns a *ValidationError listing every
+// problem, or nil.
+func ValidateBytes(name string, data []byte) error {
+	_, err := parse(name, data)
+	return err
+}
+
+// parse decodes data into a Root after validating it. Syntax errors are
+// returned as-is; schema problems as a *ValidationError.
+func parse(file string, data []byte) (*Root, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	var r Root
+	if len(doc.Content) == 0 {
+		return &r, nil
+	}
+	root := doc.Content[0]
+
+	v := &validator{file: file}
+	v.checkFields(root, reflect.TypeOf(r))
+	if err := root.Decode(&r); err != nil {
+		// Structural problems usually explain a failed decode; report those
+		if len(v.problems) > 0 {
+			return nil, v.err()
+		}
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	v.checkRoot(root, &r)
+	if len(v.problems) > 0 {
+		return nil, v.err()
+	}
+	return &r, nil
+}
+
+type validator struct {
+	file     string
+	problems []Problem
+}
+
+func (v *validator) add(n *yaml.Node, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{
+		File:    v.file,
+		Line:    n.Line,
+		Column:  n.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) err() error {
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].Line == v.problems[j].Line {
+			return v.problems[i].Column < v.problems[j].Column
+		}
+		return v.problems[i].Line < v.problems[j].Line
+	})
+	return &ValidationError{Problems: v.problems}
+}
+
+// checkFields walks the node tree alongside the Go type it will decode into
+// and reports unknown keys and structural mismatches.
+func (v *validator) checkFields(n *yaml.Node, t reflect.Type) {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			v.add(n, "expected a mapping")
+			return
+		}
+		fields, inline := yamlFields(t)
+		// An inline map takes the registered toolchain kinds, such as the
+		// version sets under runtime
+		candidates := fields
+		if inline != nil {
+			candidates = make(map[string]reflect.StructField, len(fields))
+			for k, f := range fields {
+				candidates[k] = f
+			}
+			for _, kind := range types() {
+				candidates[kind] = reflect.StructField{}
+			}
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			f, ok := fields[key.Value]
+			if _, kind := candidates[key.Value]; !ok && inline != nil && kind {
+				v.checkFields(val, inline.Elem())
+				continue
+			}
+			if !ok {
+				msg := fmt.Sprintf("unknown field %q", key.Value)
+				if s := suggest(key.Value, candidates); s != "" {
+					msg += fmt.Sprintf(" (did you mean %q?)", s)
+				}
+				v.add(key, "%s", msg)
+				continue
+			}
+			v.checkFields(val, f.Type)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			v.add(n, "expected a list")
+			return
+		}
+		for _, item := range n.Content {
+			v.checkFields(item, t.Elem())
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			v.add(n, "expected a mapping")
+			return
+		}
+		for i := 1; i < len(n.Content); i += 2 {
+			v.checkFields(n.Content[i], t.Elem())
+		}
+	default:
+		if n.Kind != yaml.ScalarNode {
+			v.add(n, "expected a single value")
+		}
+	}
+}
+
+// yamlFields maps yaml key names to struct fields. It also returns the type
+// of an inline map field, which accepts the registered toolchain kinds.
+func yamlFields(t reflect.Type) (map[string]reflect.StructField, reflect.Type) {
+	fields := map[string]reflect.StructField{}
+	var inline reflect.Type
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		opts := strings.Split(f.Tag.Get("yaml"), ",")
+		name := opts[0]
+		if name == "-" {
+			continue
+		}
+		if contains(opts[1:], "inline") && f.Type.Kind() == reflect.Map {
+			inline = f.Type
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields, inline
+}
+
+// suggest returns the known key closest to name, if it is a likely typo.
+func suggest(name string, fields map[string]reflect.StructField) string {
+	best, bestDist := "", 3
+	for k := range fields {
+		d := editDistance(strings.ToLower(name), strings.ToLower(k))
+		if d < bestDist || (d == bestDist && best != "" && k < best) {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// checkRoot performs semantic checks on the decoded config, using the node
+// tree for positions.
+func (v *validator) checkRoot(root *yaml.Node, r *Root) {
+	if rt := lookup(root, "runtime"); rt != nil {
+		for i := 0; i+1 < len(rt.Content); i += 2 {
+			v.checkVersions(lookup(rt.Content[i+1], "versions"))
+		}
+	}
+
+	if defaults := lookup(root, "defaults"); defaults != nil {
+		v.checkDuration(lookup(defaults, "timeout"), r.Defaults.Timeout)
+		v.checkDuration(lookup(defaults, "retryBackoff"), r.Defaults.RetryBackoff)
+		if r.Defaults.Retries < 0 {
+			v.add(lookup(defaults, "retries"), "retries must not be negative")
+		}
+		v.checkEnv(defaults, r.Defaults.Env, r.Defaults.Secrets)
+		v.checkDepCache(lookup(defaults, "depCache"), r.Defaults.DepCache)
+		v.checkSandbox(lookup(defaults, "sandbox"), r.Defaults.Sandbox)
+	}
+
+	if images := lookup(root, "images"); images != nil {
+		v.checkImages(images, r.Images)
+	}
+
+	if r.Defaults.Engine != "" {
+		if _, err := engine.New(r.Defaults.Engine); err != nil {
+			v.add(lookup(lookup(root, "defaults"), "engine"), "%s", err)
+		}
+	}
+
+	matrix := lookup(root, "matrix")
+	if matrix == nil {
+		return
+	}
+	seen := map[string]bool{}
+	paths := map[string]bool{}
+	for _, m := range r.Matrix {
+		paths[m.Path] = true
+	}
+	for i, m := range r.Matrix {
+		n := matrix.Content[i]
+		at := func(key string) *yaml.Node {
+			if k := lookupKey(n, key); k != nil {
+				return k
+			}
+			return n
+		}
+
+		if strings.TrimSpace(m.Path) == "" {
+			v.add(at("path"), "path must not be empty")
+		} else if err := validatePath(m.Path); err != nil {
+			v.add(lookup(n, "path"), "path %q must be relative and stay inside the workspace", m.Path)
+		}
+
+		// An empty type is detected from the project's files at plan time
+		if known := types(); m.Type != "" && !contains(known, m.Type) {
+			v.add(lookup(n, "type"), "unknown type %q (expected one of %s)", m.Type, strings.Join(known, ", "))
+		}
+
+		id := m.Path + "|" + m.Type
+		if seen[id] {
+			v.add(n, "duplicate matrix entry for %s (%s)", m.Path, m.Type)
+		}
+		seen[id] = true
+
+		v.checkVersions(lookup(n, "frameworks"))
+		v.checkVersions(lookup(n, "nodeVersions"))
+		v.checkVersions(lookup(n, "goVersions"))
+		v.checkVersions(lookup(n, "pythonVersions"))
+		v.checkVersions(lookup(n, "javaVersions"))
+		v.checkVersions(lookup(n, "rustVersions"))
+		v.checkVersions(lookup(n, "versions"))
+
+		if m.ArtifactDir != "" {
+			if err := validatePath(m.ArtifactDir); err != nil {
+				v.add(lookup(n, "artifactDir"), "artifactDir %q must be relative to the project", m.ArtifactDir)
+			}
+		}
+
+		v.checkDuration(lookup(n, "timeout"), m.Timeout)
+		if m.Retries != nil && *m.Retries < 0 {
+			v.add(lookup(n, "retries"), "retries must not be negative")
+		}
+
+		// Hooks run arbitrary shell commands, so they must be opted in to
+		if (len(m.PreBuild) > 0 || len(m.PostBuild) > 0) && !m.AllowScripts && !r.Defaults.AllowScripts {
+			key := lookupKey(n, "preBuild")
+			if key == nil {
+				key = lookupKey(n, "postBuild")
+			}
+			v.add(key, "preBuild/postBuild hooks run arbitrary shell commands; set allowScripts: true on the entry or in defaults to enable them")
+		}
+		for _, hooks := range []string{"preBuild", "postBuild"} {
+			if list := lookup(n, hooks); list != nil && list.Kind == yaml.SequenceNode {
+				for _, item := range list.Content {
+					if strings.TrimSpace(item.Value) == "" {
+						v.add(item, "%s command must not be empty", hooks)
+					}
+				}
+			}
+		}
+
+		v.checkEnv(n, m.Env, m.Secrets)
+		if m.DepCache != nil {
+			v.checkDepCache(lookup(n, "depCache"), *m.DepCache)
+		}
+		if m.Sandbox != nil {
+			v.checkSandbox(lookup(n, "sandbox"), *m.Sandbox)
+		}
+
+		if deps := lookup(n, "dependsOn"); deps != nil {
+			for j, dep := range m.DependsOn {
+				if !paths[dep] {
+					v.add(deps.Content[j], "dependsOn references unknown path %q", dep)
+				} else if dep == m.Path {
+					v.add(deps.Content[j], "project cannot depend on itself")
+				}
+			}
+		}
+
+		if m.Dotnet != nil {
+			if m.Type != "" && m.Type != "dotnet" {
+				v.add(lookupKey(n, "dotnet"), "dotnet settings only apply to entries of type dotnet")
+			}
+			v.checkDotnet(lookup(n, "dotnet"), m.Dotnet)
+		}
+
+		if m.Test != nil {
+			if tn := lookup(n, "test"); tn != nil {
+				if res := lookup(tn, "results"); res != nil && res.Kind == yaml.SequenceNode {
+					for j, pattern := range m.Test.Results {
+						if err := validatePath(pattern); err != nil || strings.TrimSpace(pattern) == "" {
+							v.add(res.Content[j], "test result pattern %q must be relative to the project", pattern)
+						}
+					}
+				}
+			}
+		}
+
+		if m.Docker != nil {
+			v.checkDocker(lookup(n, "docker"), m.Docker)
+		}
+	}
+}
+
+func (v *validator) checkDotnet(n *yaml.Node, d *DotnetConfig) {
+	at := func(key string) *yaml.Node {
+		if k := lookup(n, key); k != nil {
+			return k
+		}
+		return n
+	}
+	switch d.Mode {
+	case "", "build", "publish", "pack":
+	default:
+		v.add(at("mode"), "unknown dotnet mode %q (expected build, publish or pack)", d.Mode)
+	}
+	if d.Mode == "pack" && len(d.RuntimeIdentifiers) > 0 {
+		v.add(at("runtimeIdentifiers"), "runtimeIdentifiers do not apply to dotnet pack")
+	}
+	v.checkVersions(lookup(n, "runtimeIdentifiers"))
+	if d.Project != "" && d.Solution != "" {
+		v.add(at("solution"), "set either dotnet.project or dotnet.solution, not both")
+	}
+	for _, key := range []string{"project", "solution"} {
+		file := d.Project
+		if key == "solution" {
+			file = d.Solution
+		}
+		if file != "" {
+			if err := validatePath(file); err != nil {
+				v.add(at(key), "dotnet.%s %q must be relative to the project", key, file)
+			}
+		}
+	}
+	if props := lookup(n, "properties"); props != nil && props.Kind == yaml.MappingNode {
+		for i := 0; i < len(props.Content); i += 2 {
+			if !msbuildPropertyRegex.MatchString(props.Content[i].Value) {
+				v.add(props.Content[i], "invalid MSBuild property name %q", props.Content[i].Value)
+			}
+		}
+	}
+}
+
+// msbuildPropertyRegex matches an MSBuild property name.
+var msbuildPropertyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+func (v *validator) checkDocker(n *yaml.Node, d *DockerConfig) {
+	if !d.Enabled {
+		return
+	}
+	if d.Repository == "" {
+		v.add(n, "docker.repository is required when docker is enabled")
+	} else if !repositoryRegex.MatchString(d.Repository) {
+		v.add(lookup(n, "repository"), "invalid docker repository %q (lowercase name, optionally prefixed by a registry host)", d.Repository)
+	}
+	if tags := lookup(n, "tags"); tags != nil {
+		for i, tag := range d.Tags {
+			if !tagRegex.MatchString(tag) {
+				v.add(tags.Content[i], "invalid docker tag %q", tag)
+			}
+		}
+	}
+	if d.Dockerfile != "" {
+		if err := validatePath(d.Dockerfile); err != nil {
+			v.add(lookup(n, "dockerfile"), "dockerfile %q must be relative to the project", d.Dockerfile)
+		}
+	}
+}
+
+// checkEnv checks the env and secrets keys of the mapping n.
+func (v *validator) checkEnv(n *yaml.Node, env map[string]string, secrets []SecretConfig) {
+	if en := lookup(n, "env"); en != nil && en.Kind == yaml.MappingNode {
+		for i := 0; i < len(en.Content); i += 2 {
+			if !envNameRegex.MatchString(en.Content[i].Value) {
+				v.add(en.Content[i], "invalid environment variable name %q", en.Content[i].Value)
+			}
+		}
+	}
+	list := lookup(n, "secrets")
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return
+	}
+	seen := map[string]bool{}
+	for i, s := range secrets {
+		sn := list.Content[i]
+		at := func(key string) *yaml.Node {
+			if k := lookup(sn, key); k != nil {
+				return k
+			}
+			return sn
+		}
+		switch {
+		case s.Name == "":
+			v.add(sn, "secret name is required")
+		case !envNameRegex.MatchString(s.Name):
+			v.add(at("name"), "invalid secret name %q (it must be an environment variable name)", s.Name)
+		case seen[s.Name]:
+			v.add(at("name"), "duplicate secret %q", s.Name)
+		}
+		seen[s.Name] = true
+		if _, ok := env[s.Name]; ok && s.Name != "" {
+			v.add(at("name"), "%s is set both in env and as a secret", s.Name)
+		}
+		if s.Env != "" && s.File != "" {
+			v.add(at("file"), "set either env or file on secret %q, not both", s.Name)
+		}
+		if s.Env != "" && !envNameRegex.MatchString(s.Env) {
+			v.add(at("env"), "invalid environment variable name %q", s.Env)
+		}
+	}
+}
+
+// envNameRegex matches a portable environment variable name.
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (v *validator) checkDepCache(n *yaml.Node, d DepCacheConfig) {
+	switch d.Mode {
+	case "", "shared", "isolated", "off":
+	default:
+		at := lookup(n, "mode")
+		if at == nil {
+			at = n
+		}
+		v.add(at, "unknown depCache mode %q (expected shared, isolated or off)", d.Mode)
+	}
+}
+
+func (v *validator) checkImages(n *yaml.Node, c ImagesConfig) {
+	overrides := lookup(n, "overrides")
+	known := types()
+	for kind, image := range c.Overrides {
+		at := lookupKey(overrides, kind)
+		if !contains(known, kind) {
+			v.add(at, "unknown toolchain %q in image overrides (expected one of %s)", kind, strings.Join(known, ", "))
+		}
+		if strings.TrimSpace(image) == "" || strings.ContainsAny(image, " \t\n") {
+			v.add(lookup(overrides, kind), "invalid image %q for %s", image, kind)
+		}
+	}
+	if list := lookup(n, "allowedRegistries"); list != nil && list.Kind == yaml.SequenceNode {
+		for _, item := range list.Content {
+			if strings.TrimSpace(item.Value) == "" || strings.Contains(item.Value, "/") {
+				v.add(item, "invalid registry %q (expected a host such as docker.io or registry.example.com:5000)", item.Value)
+			}
+		}
+	}
+}
+
+func (v *validator) checkSandbox(n *yaml.Node, s SandboxConfig) {
+	at := func(key string) *yaml.Node {
+		if k := lookup(n, key); k != nil {
+			return k
+		}
+		return n
+	}
+	switch s.Network {
+	case "", "default", "none":
+	default:
+		v.add(at("network"), "unknown sandbox network %q (expected default or none)", s.Network)
+	}
+	if s.CPUs != nil && *s.CPUs < 0 {
+		v.add(at("cpus"), "cpus must not be negative")
+	}
+	if s.Memory != "" {
+		if _, err := ParseSize(s.Memory); err != nil {
+			v.add(at("memory"), "%s", err)
+		}
+	}
+	if s.Pids != nil && *s.Pids < 0 {
+		v.add(at("pids"), "pids must not be negative")
+	}
+}
+
+// checkDuration reports a value that is not a positive Go duration such
+// as "90s" or "20m".
+func (v *validator) checkDuration(n *yaml.Node, value string) {
+	if n == nil || value == "" {
+		return
+	}
+	if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+		v.add(n, "invalid duration %q (expected a positive value such as 90s or 20m)", value)
+	}
+}
+
+// checkVersions reports empty entries in a version list.
+func (v *validator) checkVersions(n *yaml.Node) {
+	if n == nil || n.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range n.Content {
+		if strings.TrimSpace(item.Value) == "" {
+			v.add(item, "version must not be empty")
+		}
+	}
+}
+
+// lookup returns the value node for key in a mapping node, or nil.
+func lookup(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// lookupKey returns the key node for key in a mapping node, or nil.
+func lookupKey(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i]
+		}
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
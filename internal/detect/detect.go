@@ -1,70 +1,40 @@
 package detect
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"slick-autobuild/internal/toolchain"
 )
 
 // ProjectType represents the detected project type
-type ProjectType struct {
-	Kind           string   // "dotnet", "node"
-	Frameworks     []string // For dotnet projects
-	PackageManager string   // For node projects  
-	BuildScripts   []string // For node projects
-}
+type ProjectType = toolchain.ProjectType
 
-// InferProjectType attempts to detect the project type based on files in the directory
+// InferProjectType attempts to detect the project type based on files in the
+// directory, asking each registered toolchain in turn.
 func InferProjectType(projectPath string) *ProjectType {
-	// Check for .NET projects
-	if hasDotNetFiles(projectPath) {
-		return &ProjectType{
-			Kind: "dotnet",
+	for _, tc := range toolchain.All() {
+		pt := tc.Detect(projectPath)
+		if pt == nil {
+			continue
 		}
-	}
-	
-	// Check for Node.js projects  
-	if hasNodeFiles(projectPath) {
-		pt := &ProjectType{
-			Kind: "node",
+		if pt.Kind == "node" {
+			switch {
+			case HasAngularFiles(projectPath):
+				pt.Framework = "angular"
+			case HasNextFiles(projectPath):
+				pt.Framework = "next"
+			case HasViteFiles(projectPath):
+				pt.Framework = "vite"
+			}
 		}
-		
-		// Detect package manager
-		if hasFile(projectPath, "pnpm-lock.yaml") {
-			pt.PackageManager = "pnpm"
-		} else if hasFile(projectPath, "yarn.lock") {
-			pt.PackageManager = "yarn"
-		} else {
-			pt.PackageManager = "npm"
-		}
-		
-		// Set default build script
-		pt.BuildScripts = []string{"build"}
-		
 		return pt
 	}
-	
 	return nil
 }
 
-// hasDotNetFiles checks for .NET project indicators
-func hasDotNetFiles(projectPath string) bool {
-	patterns := []string{"*.csproj", "*.fsproj", "*.vbproj", "*.sln"}
-	
-	for _, pattern := range patterns {
-		matches, _ := filepath.Glob(filepath.Join(projectPath, pattern))
-		if len(matches) > 0 {
-			return true
-		}
-	}
-	
-	return false
-}
-
-// hasNodeFiles checks for Node.js project indicators  
-func hasNodeFiles(projectPath string) bool {
-	return hasFile(projectPath, "package.json")
-}
-
 // hasFile checks if a file exists in the given directory
 func hasFile(projectPath, filename string) bool {
 	fullPath := filepath.Join(projectPath, filename)
@@ -97,4 +67,82 @@ func HasViteFiles(projectPath string) bool {
 		}
 	}
 	return false
-}
\ No newline at end of file
+}
+
+// SkipDirs are directory names Scan never descends into.
+var SkipDirs = map[string]bool{
+	"node_modules": true,
+	"bin":          true,
+	"obj":          true,
+	"dist":         true,
+	"out":          true,
+	".buildcache":  true,
+}
+
+// Project is a project found by Scan.
+type Project struct {
+	Path string // relative to the scanned root, slash-separated
+	*ProjectType
+}
+
+// Scan walks root and returns every project InferProjectType recognises,
+// sorted by path. Hidden directories and SkipDirs are not visited.
+func Scan(root string) ([]Project, error) {
+	var projects []Project
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		name := d.Name()
+		if path != root && (SkipDirs[name] || strings.HasPrefix(name, ".")) {
+			return filepath.SkipDir
+		}
+		pt := InferProjectType(path)
+		if pt == nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		projects = append(projects, Project{Path: filepath.ToSlash(rel), ProjectType: pt})
+		return nil
+	})
+	return projects, err
+}
+
+// DotnetSDKVersion returns the SDK version pinned by global.json in dir, if any.
+func DotnetSDKVersion(dir string) string {
+	var global struct {
+		SDK struct {
+			Version string `json:"version"`
+		} `json:"sdk"`
+	}
+	// #nosec G304 - Reads global.json from a scanned project directory
+	data, err := os.ReadFile(filepath.Join(dir, "global.json"))
+	if err != nil {
+		return ""
+	}
+	_ = json.Unmarshal(data, &global)
+	return global.SDK.Version
+}
+
+// NodeVersion returns the Node.js version pinned by .nvmrc or .node-version
+// in dir, if any.
+func NodeVersion(dir string) string {
+	for _, name := range []string{".nvmrc", ".node-version"} {
+		// #nosec G304 - Reads version files from a scanned project directory
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		v := strings.TrimPrefix(strings.TrimSpace(string(data)), "v")
+		if v != "" && !strings.Contains(v, "/") {
+			return v
+		}
+	}
+	return ""
+}
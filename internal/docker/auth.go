@@ -0,0 +1,160 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubServer is the key Docker Hub credentials are stored under.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// AuthConfig holds registry credentials for pulls and pushes.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	ServerAddress string `json:"serveraddress,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// encode returns the X-Registry-Auth header value.
+func (a AuthConfig) encode() string {
+	data, _ := json.Marshal(a)
+	return base64.URLEncoding.EncodeToString(data)
+}
+
+// Login checks credentials against a registry and keeps them for later
+// pushes and pulls through this client.
+func (c *Client) Login(ctx context.Context, auth AuthConfig) error {
+	if err := c.doJSON(ctx, http.MethodPost, "/auth", nil, auth, nil); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.auths[registryKey(auth.ServerAddress)] = auth
+	c.mu.Unlock()
+	return nil
+}
+
+// authFor returns credentials for registry: those passed to Login first,
+// then the docker CLI config (including credential helpers). Anonymous
+// access is used when none are found.
+func (c *Client) authFor(registry string) AuthConfig {
+	key := registryKey(registry)
+	c.mu.Lock()
+	auth, ok := c.auths[key]
+	c.mu.Unlock()
+	if ok {
+		return auth
+	}
+	if auth, ok := configAuth(key); ok {
+		return auth
+	}
+	return AuthConfig{ServerAddress: key}
+}
+
+// registryKey normalises a registry host to the key the docker CLI uses.
+func registryKey(registry string) string {
+	switch registry {
+	case "", "docker.io", "index.docker.io", "registry-1.docker.io":
+		return dockerHubServer
+	}
+	return registry
+}
+
+// RegistryOf returns the registry host of an image reference; references
+// without one come from docker.io.
+func RegistryOf(ref string) string {
+	first, _, ok := strings.Cut(ref, "/")
+	if ok && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return first
+	}
+	return "docker.io"
+}
+
+// configDir returns the docker CLI config directory: $DOCKER_CONFIG, or
+// ~/.docker.
+func configDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// configAuth looks registry up in $DOCKER_CONFIG/config.json (default
+// ~/.docker/config.json).
+func configAuth(registry string) (AuthConfig, bool) {
+	// #nosec G304 - Reads the docker CLI config from its well-known location
+	data, err := os.ReadFile(filepath.Join(configDir(), "config.json"))
+	if err != nil {
+		return AuthConfig{}, false
+	}
+	var cfg struct {
+		Auths map[string]struct {
+			Auth          string `json:"auth"`
+			IdentityToken string `json:"identitytoken"`
+		} `json:"auths"`
+		CredsStore  string            `json:"credsStore"`
+		CredHelpers map[string]string `json:"credHelpers"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return AuthConfig{}, false
+	}
+
+	helper := cfg.CredHelpers[registry]
+	if helper == "" {
+		helper = cfg.CredsStore
+	}
+	if helper != "" {
+		if auth, err := helperAuth(helper, registry); err == nil {
+			return auth, true
+		}
+	}
+
+	entry, ok := cfg.Auths[registry]
+	if !ok {
+		return AuthConfig{}, false
+	}
+	auth := AuthConfig{ServerAddress: registry, IdentityToken: entry.IdentityToken}
+	if entry.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return AuthConfig{}, false
+		}
+		auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+	}
+	return auth, true
+}
+
+// helperAuth asks a docker-credential-<helper> program for credentials.
+func helperAuth(helper, registry string) (AuthConfig, error) {
+	// #nosec G204 - The helper name comes from the user's docker CLI config
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(registry)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return AuthConfig{}, fmt.Errorf("credential helper %s: %w", helper, err)
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+		return AuthConfig{}, fmt.Errorf("credential helper %s: %w", helper, err)
+	}
+	auth := AuthConfig{ServerAddress: registry}
+	if creds.Username == "<token>" {
+		auth.IdentityToken = creds.Secret
+	} else {
+		auth.Username, auth.Password = creds.Username, creds.Secret
+	}
+	return auth, nil
+}
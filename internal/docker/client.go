@@ -0,0 +1,313 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"slick-autobuild/internal/logging"
+)
+
+// DefaultHost is the daemon address used when DOCKER_HOST is not set.
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// ErrUnavailable is returned when the daemon cannot be reached.
+var ErrUnavailable = errors.New("docker daemon is not reachable")
+
+// ErrCLIOnly is returned by NewClient for daemons that only the docker CLI
+// knows how to reach: ssh:// and npipe:// hosts, and docker contexts.
+var ErrCLIOnly = errors.New("daemon is only reachable through the docker CLI")
+
+// APIError is a non-success response from the Engine API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker api: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// StreamError is a failure reported inside a build, pull or push progress
+// stream; the HTTP request itself succeeded.
+type StreamError struct {
+	Op      string
+	Code    int
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("docker %s: %s", e.Op, e.Message)
+}
+
+// IsNotFound reports whether err is an API error for a missing image or
+// container.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// Client talks to the Docker Engine HTTP API.
+type Client struct {
+	host string
+	base string
+	http *http.Client
+
+	mu    sync.Mutex
+	auths map[string]AuthConfig
+}
+
+// NewClient returns a client for host, a DOCKER_HOST style address
+// (unix:///path or tcp://host:port). An empty host reads DOCKER_HOST and
+// falls back to the current docker context, then DefaultHost. Addresses
+// and contexts the client cannot use give an ErrCLIOnly error. TCP connections use TLS when DOCKER_TLS_VERIFY
+// is set, with certificates from DOCKER_CERT_PATH.
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		if name := currentContext(); name != "" && name != "default" {
+			return nil, fmt.Errorf("docker context %q: %w", name, ErrCLIOnly)
+		}
+		host = DefaultHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %q: %w", host, err)
+	}
+
+	transport := &http.Transport{}
+	c := &Client{host: host, auths: map[string]AuthConfig{}}
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		c.base = "http://docker"
+	case "tcp":
+		c.base = "http://" + u.Host
+		if os.Getenv("DOCKER_TLS_VERIFY") != "" {
+			cfg, err := tlsConfig(os.Getenv("DOCKER_CERT_PATH"))
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = cfg
+			c.base = "https://" + u.Host
+		}
+	default:
+		return nil, fmt.Errorf("DOCKER_HOST %q: %w", host, ErrCLIOnly)
+	}
+	c.http = &http.Client{Transport: transport}
+	return c, nil
+}
+
+// currentContext returns the docker context the CLI would use:
+// $DOCKER_CONTEXT, or currentContext in the CLI config.
+func currentContext() string {
+	if name := os.Getenv("DOCKER_CONTEXT"); name != "" {
+		return name
+	}
+	// #nosec G304 - Reads the docker CLI config from its well-known location
+	data, err := os.ReadFile(filepath.Join(configDir(), "config.json"))
+	if err != nil {
+		return ""
+	}
+	var cfg struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	_ = json.Unmarshal(data, &cfg)
+	return cfg.CurrentContext
+}
+
+// tlsConfig loads the client certificate and CA from dir, which defaults to
+// ~/.docker like the docker CLI.
+func tlsConfig(dir string) (*tls.Config, error) {
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".docker")
+	}
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("load docker TLS certificate: %w", err)
+	}
+	// #nosec G304 - Reads the CA from the configured docker certificate directory
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("load docker TLS CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	return &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// Ping checks that the daemon is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a request and returns the response for 2xx status codes. Other
+// status codes are returned as *APIError.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w at %s: %v", ErrUnavailable, c.host, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var msg struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &msg) != nil || msg.Message == "" {
+		msg.Message = strings.TrimSpace(string(data))
+	}
+	return nil, &APIError{StatusCode: resp.StatusCode, Message: msg.Message}
+}
+
+// doJSON sends in as a JSON body (if non-nil) and decodes the response into
+// out (if non-nil).
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+	header := http.Header{}
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+		header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.do(ctx, method, path, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Event is one message of a build, pull or push progress stream.
+type Event struct {
+	Stream   string          `json:"stream"`
+	Status   string          `json:"status"`
+	ID       string          `json:"id"`
+	Progress *EventProgress  `json:"progressDetail"`
+	Aux      json.RawMessage `json:"aux"`
+	Detail   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errorDetail"`
+	Error string `json:"error"`
+}
+
+// EventProgress is the byte progress of a layer transfer.
+type EventProgress struct {
+	Current int64 `json:"current"`
+	Total   int64 `json:"total"`
+}
+
+// readStream decodes a progress stream, logging each event and passing it
+// to fn. An error event ends the stream as a *StreamError.
+func readStream(r io.Reader, op string, logger *logging.Logger, fn func(Event)) error {
+	dec := json.NewDecoder(r)
+	for {
+		var ev Event
+		if err := dec.Decode(&ev); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("docker %s: read progress: %w", op, err)
+		}
+		if ev.Detail != nil || ev.Error != "" {
+			se := &StreamError{Op: op, Message: ev.Error}
+			if ev.Detail != nil {
+				se.Code = ev.Detail.Code
+				if ev.Detail.Message != "" {
+					se.Message = ev.Detail.Message
+				}
+			}
+			return se
+		}
+		logEvent(logger, op, ev)
+		if fn != nil {
+			fn(ev)
+		}
+	}
+}
+
+// logEvent records build output and layer status changes. Intermediate
+// transfer progress is dropped to keep logs readable.
+func logEvent(logger *logging.Logger, op string, ev Event) {
+	if logger == nil {
+		return
+	}
+	switch {
+	case strings.TrimSpace(ev.Stream) != "":
+		logger.Info("docker "+op, map[string]interface{}{"line": strings.TrimRight(ev.Stream, "\r\n")})
+	case ev.Status != "" && (ev.Progress == nil || ev.Progress.Total == 0):
+		kv := map[string]interface{}{"status": ev.Status}
+		if ev.ID != "" {
+			kv["id"] = ev.ID
+		}
+		logger.Info("docker "+op, kv)
+	}
+}
+
+// Rootless reports whether the daemon runs rootless, mapping the containers'
+// root user to the user running the daemon.
+func (c *Client) Rootless(ctx context.Context) (bool, error) {
+	var info struct {
+		SecurityOptions []string `json:"SecurityOptions"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/info", nil, nil, &info); err != nil {
+		return false, err
+	}
+	return hasRootless(info.SecurityOptions), nil
+}
+
+func hasRootless(securityOptions []string) bool {
+	for _, opt := range securityOptions {
+		if opt == "name=rootless" {
+			return true
+		}
+	}
+	return false
+}
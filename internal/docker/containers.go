@@ -0,0 +1,159 @@
+package docker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"slick-autobuild/internal/logging"
+)
+
+// ContainerSpec describes a one-off build container.
+type ContainerSpec struct {
+	Name       string
+	Image      string
+	Cmd        []string
+	WorkingDir string
+	Env        []string
+	// User is "uid:gid" to run as; empty keeps the image's user.
+	User string
+	// Binds are -v style "source:target[:options]" mounts.
+	Binds []string
+	// NetworkMode is "none" to cut the container off the network; empty
+	// uses the daemon's default network.
+	NetworkMode string
+	// NanoCPUs, Memory (bytes) and PidsLimit cap the container; zero means
+	// no limit.
+	NanoCPUs  int64
+	Memory    int64
+	PidsLimit int64
+}
+
+// Run creates and starts a container, streams its output to stdout and
+// stderr, and returns its exit code once it stops. The image is pulled if
+// it is missing. The container is removed afterwards, and killed if ctx is
+// cancelled while it runs.
+func (c *Client) Run(ctx context.Context, spec ContainerSpec, stdout, stderr io.Writer, logger *logging.Logger) (int, error) {
+	id, err := c.createContainer(ctx, spec)
+	if IsNotFound(err) {
+		if err = c.Pull(ctx, spec.Image, logger); err != nil {
+			return -1, err
+		}
+		id, err = c.createContainer(ctx, spec)
+	}
+	if err != nil {
+		return -1, err
+	}
+	// The task's context may be cancelled by now; clean up with a fresh one
+	defer c.cleanup(func(ctx context.Context) error {
+		return c.doJSON(ctx, http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}}, nil, nil)
+	})
+	stop := context.AfterFunc(ctx, func() {
+		c.cleanup(func(ctx context.Context) error {
+			return c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/kill", nil, nil, nil)
+		})
+	})
+	defer stop()
+
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/start", nil, nil, nil); err != nil {
+		return -1, err
+	}
+
+	query := url.Values{"follow": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+id+"/logs", query, nil, nil)
+	if err != nil {
+		return -1, err
+	}
+	err = demux(resp.Body, stdout, stderr)
+	resp.Body.Close()
+	if err != nil {
+		if ctx.Err() != nil {
+			return -1, ctx.Err()
+		}
+		return -1, fmt.Errorf("read container output: %w", err)
+	}
+
+	var wait struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/"+id+"/wait", nil, nil, &wait); err != nil {
+		return -1, err
+	}
+	if wait.Error != nil && wait.Error.Message != "" {
+		return -1, &APIError{StatusCode: http.StatusInternalServerError, Message: wait.Error.Message}
+	}
+	return wait.StatusCode, nil
+}
+
+func (c *Client) createContainer(ctx context.Context, spec ContainerSpec) (string, error) {
+	host := map[string]interface{}{"Binds": spec.Binds}
+	if spec.NetworkMode != "" {
+		host["NetworkMode"] = spec.NetworkMode
+	}
+	if spec.NanoCPUs > 0 {
+		host["NanoCpus"] = spec.NanoCPUs
+	}
+	if spec.Memory > 0 {
+		host["Memory"] = spec.Memory
+	}
+	if spec.PidsLimit > 0 {
+		host["PidsLimit"] = spec.PidsLimit
+	}
+	body := map[string]interface{}{
+		"Image":      spec.Image,
+		"Cmd":        spec.Cmd,
+		"WorkingDir": spec.WorkingDir,
+		"Env":        spec.Env,
+		"User":       spec.User,
+		"HostConfig": host,
+	}
+	var query url.Values
+	if spec.Name != "" {
+		query = url.Values{"name": {spec.Name}}
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.doJSON(ctx, http.MethodPost, "/containers/create", query, body, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// cleanup runs fn with a fresh 30s context, ignoring errors: it is used
+// for best-effort kill and remove.
+func (c *Client) cleanup(fn func(context.Context) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_ = fn(ctx)
+}
+
+// demux splits the multiplexed log stream of a non-TTY container: each
+// frame is an 8 byte header (stream type, 3 zero bytes, big-endian size)
+// followed by the payload.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	var hdr [8]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		w := stdout
+		if hdr[0] == 2 {
+			w = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(hdr[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
@@ -2,6 +2,8 @@ package docker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,12 +12,15 @@ import (
 	"strings"
 
 	"slick-autobuild/internal/config"
+	"slick-autobuild/internal/engine"
 	"slick-autobuild/internal/logging"
 )
 
 // ImageBuilder handles Docker image creation and pushing
 type ImageBuilder struct {
 	logger *logging.Logger
+	engine *engine.Engine
+	client *Client
 }
 
 // validateDockerTag ensures the Docker tag is safe
@@ -39,10 +44,17 @@ func validateRepositoryName(repo string) error {
 	return nil
 }
 
-// NewImageBuilder creates a new Docker image builder
-func NewImageBuilder(logger *logging.Logger) *ImageBuilder {
+// NewImageBuilder creates a new Docker image builder. A nil engine means
+// docker. Images are built and pushed through client when it is set, and
+// with the engine's CLI otherwise.
+func NewImageBuilder(logger *logging.Logger, eng *engine.Engine, client *Client) *ImageBuilder {
+	if eng == nil {
+		eng = engine.Default()
+	}
 	return &ImageBuilder{
 		logger: logger,
+		engine: eng,
+		client: client,
 	}
 }
 
@@ -92,43 +104,19 @@ func (ib *ImageBuilder) BuildAndPush(ctx context.Context, projectPath string, do
 	}
 
 	// Build the image with all tags
+	refs := make([]string, len(tags))
 	for i, tag := range tags {
-		fullTag := fmt.Sprintf("%s:%s", dockerConfig.Repository, tag)
-		
-		var buildArgs []string
-		if i == 0 {
-			// First build with tag
-			buildArgs = []string{"build", "-t", fullTag, "."}
-		} else {
-			// Additional tags
-			buildArgs = []string{"tag", fmt.Sprintf("%s:%s", dockerConfig.Repository, tags[0]), fullTag}
-		}
-
-		if i == 0 {
-			// Only run build once
-			// #nosec G204 - Arguments are validated and constructed from controlled data
-			cmd := exec.CommandContext(ctx, "docker", buildArgs...)
-			cmd.Dir = workDir
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("docker build failed for %s: %w", projectPath, err)
-			}
-
-			ib.logger.Info("Docker image built successfully", map[string]interface{}{
-				"path": projectPath,
-				"tag":  fullTag,
-			})
-		} else {
-			// Tag additional versions
-			// #nosec G204 - Arguments are validated and constructed from controlled data
-			cmd := exec.CommandContext(ctx, "docker", buildArgs...)
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("docker tag failed for %s: %w", fullTag, err)
-			}
-		}
+		refs[i] = ib.engine.Ref(fmt.Sprintf("%s:%s", dockerConfig.Repository, tag))
 	}
+	imageID, err := ib.build(ctx, workDir, dockerConfig.Dockerfile, refs)
+	if err != nil {
+		return fmt.Errorf("docker build failed for %s: %w", projectPath, err)
+	}
+	ib.logger.Info("Docker image built successfully", map[string]interface{}{
+		"path":  projectPath,
+		"tag":   refs[0],
+		"image": imageID,
+	})
 
 	// Push to registries if enabled
 	if dockerConfig.Push {
@@ -140,6 +128,53 @@ func (ib *ImageBuilder) BuildAndPush(ctx context.Context, projectPath string, do
 	return nil
 }
 
+// build builds the image in workDir tagged with refs and returns its ID,
+// which is only known when building through the API.
+func (ib *ImageBuilder) build(ctx context.Context, workDir, dockerfile string, refs []string) (string, error) {
+	if ib.client != nil {
+		return ib.client.Build(ctx, BuildOptions{ContextDir: workDir, Dockerfile: dockerfile, Tags: refs}, ib.logger)
+	}
+
+	args := append([]string{"build"}, ib.engine.BuildArgs()...)
+	if dockerfile != "" {
+		args = append(args, "-f", dockerfile)
+	}
+	args = append(args, "-t", refs[0], ".")
+	cmd := ib.engine.Command(ctx, args...)
+	cmd.Dir = workDir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	// Tag additional versions
+	for _, ref := range refs[1:] {
+		if err := ib.tag(ctx, refs[0], ref); err != nil {
+			return "", fmt.Errorf("docker tag failed for %s: %w", ref, err)
+		}
+	}
+	return "", nil
+}
+
+func (ib *ImageBuilder) tag(ctx context.Context, source, ref string) error {
+	if ib.client != nil {
+		return ib.client.Tag(ctx, source, ref)
+	}
+	return ib.engine.Command(ctx, "tag", source, ref).Run()
+}
+
+// push pushes ref and returns its digest, which is only known when pushing
+// through the API.
+func (ib *ImageBuilder) push(ctx context.Context, ref string) (string, error) {
+	if ib.client != nil {
+		return ib.client.Push(ctx, ref, ib.logger)
+	}
+	cmd := ib.engine.Command(ctx, "push", ref)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return "", cmd.Run()
+}
+
 // pushToRegistries pushes the built image to all configured registries
 func (ib *ImageBuilder) pushToRegistries(ctx context.Context, dockerConfig *config.DockerConfig, projectPath string) error {
 	registries := dockerConfig.Registries
@@ -168,23 +203,19 @@ func (ib *ImageBuilder) pushToRegistries(ctx context.Context, dockerConfig *conf
 				fullTag = fmt.Sprintf("%s/%s:%s", registry, dockerConfig.Repository, tag)
 			}
 
+			fullTag = ib.engine.Ref(fullTag)
+
 			// Tag for the specific registry if not Docker Hub
 			if registry != "docker.io" {
-				sourceTag := fmt.Sprintf("%s:%s", dockerConfig.Repository, tag)
-				// #nosec G204 - Arguments are validated and constructed from controlled data
-				tagCmd := exec.CommandContext(ctx, "docker", "tag", sourceTag, fullTag)
-				if err := tagCmd.Run(); err != nil {
+				sourceTag := ib.engine.Ref(fmt.Sprintf("%s:%s", dockerConfig.Repository, tag))
+				if err := ib.tag(ctx, sourceTag, fullTag); err != nil {
 					return fmt.Errorf("failed to tag image for registry %s: %w", registry, err)
 				}
 			}
 
 			// Push the image
-			// #nosec G204 - Arguments are validated and constructed from controlled data
-			pushCmd := exec.CommandContext(ctx, "docker", "push", fullTag)
-			pushCmd.Stdout = os.Stdout
-			pushCmd.Stderr = os.Stderr
-
-			if err := pushCmd.Run(); err != nil {
+			digest, err := ib.push(ctx, fullTag)
+			if err != nil {
 				return fmt.Errorf("failed to push %s to %s: %w", fullTag, registry, err)
 			}
 
@@ -192,6 +223,7 @@ func (ib *ImageBuilder) pushToRegistries(ctx context.Context, dockerConfig *conf
 				"path":     projectPath,
 				"registry": registry,
 				"tag":      fullTag,
+				"digest":   digest,
 			})
 		}
 	}
@@ -199,18 +231,106 @@ func (ib *ImageBuilder) pushToRegistries(ctx context.Context, dockerConfig *conf
 	return nil
 }
 
-// CheckDockerAvailable verifies that Docker is available and running
-func CheckDockerAvailable(ctx context.Context) error {
-	// #nosec G204 - Fixed command with no user input
-	cmd := exec.CommandContext(ctx, "docker", "version")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("Docker is not available or not running: %w", err)
+// EngineClient returns an Engine API client for eng, or nil when the
+// engine is driven through its CLI: podman and nerdctl, and docker daemons
+// only the docker CLI can reach. A nil engine means docker.
+func EngineClient(eng *engine.Engine) (*Client, error) {
+	if eng != nil && eng.Name != engine.Docker {
+		return nil, nil
 	}
-	return nil
+	client, err := NewClient("")
+	if errors.Is(err, ErrCLIOnly) {
+		return nil, nil
+	}
+	return client, err
+}
+
+// CheckDockerAvailable verifies that the chosen container engine is
+// available and running: through client when it is set, otherwise with the
+// engine's CLI. A nil engine means docker.
+func CheckDockerAvailable(ctx context.Context, eng *engine.Engine, client *Client) error {
+	if client != nil {
+		return client.Ping(ctx)
+	}
+	if eng == nil {
+		eng = engine.Default()
+	}
+	return eng.CheckAvailable(ctx)
 }
 
-// LoginToRegistry performs docker login to a registry if credentials are available
-func LoginToRegistry(ctx context.Context, registry string, logger *logging.Logger) error {
+// MapsHostUser reports whether the engine already runs containers as the
+// invoking user: rootless podman (with --userns=keep-id), and rootless
+// nerdctl or docker, whose container root is the invoking user. Files that
+// builds write to mounts then belong to that user without --user. A nil
+// engine means docker.
+func MapsHostUser(ctx context.Context, eng *engine.Engine, client *Client) bool {
+	if eng == nil {
+		eng = engine.Default()
+	}
+	if eng.Name != engine.Docker {
+		return eng.Rootless
+	}
+	if client != nil {
+		rootless, err := client.Rootless(ctx)
+		return err == nil && rootless
+	}
+	out, err := eng.Command(ctx, "info", "--format", "{{json .SecurityOptions}}").Output()
+	if err != nil {
+		return false
+	}
+	var opts []string
+	return json.Unmarshal(out, &opts) == nil && hasRootless(opts)
+}
+
+// ImageDigest returns the registry digest ("sha256:...") of the image ref,
+// pulling it first if it is not present, through client when it is set and
+// with the engine's CLI otherwise. Short names are qualified for engines
+// that need it. A nil engine means docker.
+func ImageDigest(ctx context.Context, eng *engine.Engine, client *Client, ref string, logger *logging.Logger) (string, error) {
+	if eng == nil {
+		eng = engine.Default()
+	}
+	ref = eng.Ref(ref)
+	if client != nil {
+		digests, err := client.RepoDigests(ctx, ref)
+		if IsNotFound(err) {
+			if err = client.Pull(ctx, ref, logger); err != nil {
+				return "", fmt.Errorf("pull %s: %w", ref, err)
+			}
+			digests, err = client.RepoDigests(ctx, ref)
+		}
+		if err != nil {
+			return "", fmt.Errorf("inspect %s: %w", ref, err)
+		}
+		return digestFor(ref, digests)
+	}
+	inspect := func() ([]byte, error) {
+		return eng.Command(ctx, "image", "inspect", "--format", "{{json .RepoDigests}}", ref).Output()
+	}
+	out, err := inspect()
+	if err != nil {
+		logger.Info("pulling image", map[string]interface{}{"image": ref})
+		if pullOut, err := eng.Command(ctx, "pull", ref).CombinedOutput(); err != nil {
+			return "", fmt.Errorf("pull %s: %w: %s", ref, err, strings.TrimSpace(string(pullOut)))
+		}
+		if out, err = inspect(); err != nil {
+			return "", fmt.Errorf("inspect %s: %w", ref, err)
+		}
+	}
+	var digests []string
+	if err := json.Unmarshal(out, &digests); err != nil {
+		return "", fmt.Errorf("inspect %s: %w", ref, err)
+	}
+	return digestFor(ref, digests)
+}
+
+// LoginToRegistry logs in to a registry if credentials are available. With
+// a client the credentials are checked by the daemon and kept for pushes;
+// otherwise the engine's CLI stores them.
+func LoginToRegistry(ctx context.Context, registry string, logger *logging.Logger, eng *engine.Engine, client *Client) error {
+	if eng == nil {
+		eng = engine.Default()
+	}
 	// Check for registry-specific environment variables
 	var username, password string
 	
@@ -223,7 +343,7 @@ func LoginToRegistry(ctx context.Context, registry string, logger *logging.Logge
 		password = os.Getenv("GITHUB_TOKEN")
 	case strings.Contains(registry, "amazonaws.com"):
 		// AWS ECR uses different authentication method
-		return loginToECR(ctx, registry, logger)
+		return loginToECR(ctx, registry, logger, eng, client)
 	default:
 		// Generic registry credentials
 		username = os.Getenv(fmt.Sprintf("%s_USERNAME", strings.ToUpper(strings.ReplaceAll(registry, ".", "_"))))
@@ -237,11 +357,7 @@ func LoginToRegistry(ctx context.Context, registry string, logger *logging.Logge
 		return nil
 	}
 
-	// #nosec G204 - Arguments are constructed from environment variables and validated registry names
-	cmd := exec.CommandContext(ctx, "docker", "login", "-u", username, "--password-stdin", registry)
-	cmd.Stdin = strings.NewReader(password)
-	
-	if err := cmd.Run(); err != nil {
+	if err := login(ctx, eng, client, registry, username, password); err != nil {
 		return fmt.Errorf("failed to login to registry %s: %w", registry, err)
 	}
 
@@ -254,7 +370,7 @@ func LoginToRegistry(ctx context.Context, registry string, logger *logging.Logge
 }
 
 // loginToECR handles AWS ECR authentication
-func loginToECR(ctx context.Context, registry string, logger *logging.Logger) error {
+func loginToECR(ctx context.Context, registry string, logger *logging.Logger, eng *engine.Engine, client *Client) error {
 	// Extract region from ECR URL
 	parts := strings.Split(registry, ".")
 	if len(parts) < 4 {
@@ -271,11 +387,7 @@ func loginToECR(ctx context.Context, registry string, logger *logging.Logger) er
 	}
 
 	// Login to ECR
-	// #nosec G204 - Registry name is validated from input
-	loginCmd := exec.CommandContext(ctx, "docker", "login", "--username", "AWS", "--password-stdin", registry)
-	loginCmd.Stdin = strings.NewReader(string(output))
-	
-	if err := loginCmd.Run(); err != nil {
+	if err := login(ctx, eng, client, registry, "AWS", strings.TrimSpace(string(output))); err != nil {
 		return fmt.Errorf("failed to login to ECR: %w", err)
 	}
 
@@ -285,4 +397,15 @@ func loginToECR(ctx context.Context, registry string, logger *logging.Logger) er
 	})
 
 	return nil
-}
\ No newline at end of file
+}
+
+// login authenticates through client when it is set, otherwise with the
+// engine's CLI reading the password from stdin.
+func login(ctx context.Context, eng *engine.Engine, client *Client, registry, username, password string) error {
+	if client != nil {
+		return client.Login(ctx, AuthConfig{Username: username, Password: password, ServerAddress: registryKey(registry)})
+	}
+	cmd := eng.Command(ctx, eng.LoginArgs(registry, username)...)
+	cmd.Stdin = strings.NewReader(password)
+	return cmd.Run()
+}
@@ -0,0 +1,289 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"slick-autobuild/internal/ignore"
+	"slick-autobuild/internal/logging"
+)
+
+// BuildOptions configures an image build.
+type BuildOptions struct {
+	// ContextDir is the build context sent to the daemon.
+	ContextDir string
+	// Dockerfile is relative to ContextDir; it defaults to Dockerfile.
+	Dockerfile string
+	// Tags are the full references to apply to the image.
+	Tags []string
+}
+
+// Build builds an image and returns its ID. The context honours
+// .dockerignore like the docker CLI.
+func (c *Client) Build(ctx context.Context, opts BuildOptions, logger *logging.Logger) (string, error) {
+	dockerfile := opts.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	query := url.Values{"dockerfile": {filepath.ToSlash(dockerfile)}, "rm": {"1"}}
+	for _, t := range opts.Tags {
+		query.Add("t", t)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeContext(pw, opts.ContextDir, dockerfile))
+	}()
+	defer pr.Close()
+
+	header := http.Header{"Content-Type": {"application/x-tar"}}
+	resp, err := c.do(ctx, http.MethodPost, "/build", query, header, pr)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var id string
+	err = readStream(resp.Body, "build", logger, func(ev Event) {
+		var aux struct {
+			ID string `json:"ID"`
+		}
+		if len(ev.Aux) > 0 && json.Unmarshal(ev.Aux, &aux) == nil && aux.ID != "" {
+			id = aux.ID
+		}
+	})
+	return id, err
+}
+
+// Tag adds ref as a name for the image source.
+func (c *Client) Tag(ctx context.Context, source, ref string) error {
+	repo, tag := splitRef(ref)
+	query := url.Values{"repo": {repo}, "tag": {tag}}
+	return c.doJSON(ctx, http.MethodPost, "/images/"+source+"/tag", query, nil, nil)
+}
+
+// Push pushes ref and returns the manifest digest reported by the registry.
+func (c *Client) Push(ctx context.Context, ref string, logger *logging.Logger) (string, error) {
+	repo, tag := splitRef(ref)
+	header := http.Header{"X-Registry-Auth": {c.authFor(RegistryOf(ref)).encode()}}
+	resp, err := c.do(ctx, http.MethodPost, "/images/"+repo+"/push", url.Values{"tag": {tag}}, header, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var digest string
+	err = readStream(resp.Body, "push", logger, func(ev Event) {
+		var aux struct {
+			Digest string `json:"Digest"`
+		}
+		if len(ev.Aux) > 0 && json.Unmarshal(ev.Aux, &aux) == nil && aux.Digest != "" {
+			digest = aux.Digest
+		}
+	})
+	return digest, err
+}
+
+// Pull downloads ref.
+func (c *Client) Pull(ctx context.Context, ref string, logger *logging.Logger) error {
+	repo, tag := splitRef(ref)
+	header := http.Header{"X-Registry-Auth": {c.authFor(RegistryOf(ref)).encode()}}
+	query := url.Values{"fromImage": {repo}, "tag": {tag}}
+	resp, err := c.do(ctx, http.MethodPost, "/images/create", query, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return readStream(resp.Body, "pull", logger, nil)
+}
+
+// RepoDigests returns the registry digests of a local image, as
+// "name@sha256:..." references.
+func (c *Client) RepoDigests(ctx context.Context, ref string) ([]string, error) {
+	var inspect struct {
+		RepoDigests []string `json:"RepoDigests"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/images/"+ref+"/json", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+	return inspect.RepoDigests, nil
+}
+
+// digestFor picks the digest of ref's repository from an image's repo
+// digests. Engines spell Docker Hub names differently, so they are
+// compared without the docker.io/library/ prefix.
+func digestFor(ref string, repoDigests []string) (string, error) {
+	repo, _ := splitRef(ref)
+	for _, rd := range repoDigests {
+		name, digest, ok := strings.Cut(rd, "@")
+		if ok && familiarName(name) == familiarName(repo) {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("image %s has no registry digest; only pulled images can be locked", ref)
+}
+
+// familiarName shortens a Docker Hub repository to the form the docker CLI
+// shows, such as node for docker.io/library/node.
+func familiarName(repo string) string {
+	for _, prefix := range []string{"docker.io/", "index.docker.io/"} {
+		repo = strings.TrimPrefix(repo, prefix)
+	}
+	return strings.TrimPrefix(repo, "library/")
+}
+
+// splitRef splits an image reference into repository and tag; the tag
+// defaults to latest. For a reference pinned to a digest the digest is
+// returned as the tag, which is how the pull API takes it.
+func splitRef(ref string) (repo, tag string) {
+	if name, digest, ok := strings.Cut(ref, "@"); ok {
+		repo, _ = splitRef(name)
+		return repo, digest
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+// writeContext writes dir as a tar stream, leaving out paths matched by its
+// .dockerignore. The Dockerfile is always included.
+func writeContext(w io.Writer, dir, dockerfile string) error {
+	ignore, err := loadDockerignore(filepath.Join(dir, ".dockerignore"))
+	if err != nil {
+		return err
+	}
+	keep := filepath.ToSlash(filepath.Clean(dockerfile))
+
+	tw := tar.NewWriter(w)
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != keep && rel != ".dockerignore" && ignore.excluded(rel) {
+			// Re-included children need the walk to continue
+			if d.IsDir() && !ignore.negates {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		// Ownership on the host is meaningless inside the image
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		// #nosec G304 - Reads files inside the build context directory
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("build context: %w", err)
+	}
+	return tw.Close()
+}
+
+// dockerignore holds .dockerignore rules; the last matching rule wins.
+type dockerignore struct {
+	rules   []ignoreRule
+	negates bool
+}
+
+type ignoreRule struct {
+	re     *regexp.Regexp
+	negate bool
+}
+
+func loadDockerignore(path string) (*dockerignore, error) {
+	di := &dockerignore{}
+	// #nosec G304 - Reads .dockerignore from the build context directory
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return di, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			r.negate = true
+			di.negates = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(line)), "/")
+		re, err := regexp.Compile("^" + ignore.GlobToRegexp(line) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid .dockerignore pattern %q: %w", line, err)
+		}
+		r.re = re
+		di.rules = append(di.rules, r)
+	}
+	return di, sc.Err()
+}
+
+// excluded reports whether rel, or one of its parent directories, matches.
+func (di *dockerignore) excluded(rel string) bool {
+	excluded := false
+	for _, r := range di.rules {
+		for s := rel; s != ""; {
+			if r.re.MatchString(s) {
+				excluded = !r.negate
+				break
+			}
+			i := strings.LastIndex(s, "/")
+			if i < 0 {
+				break
+			}
+			s = s[:i]
+		}
+	}
+	return excluded
+}
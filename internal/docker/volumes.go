@@ -0,0 +1,85 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"slick-autobuild/internal/engine"
+)
+
+// Volumes returns the names of the volumes starting with prefix.
+func (c *Client) Volumes(ctx context.Context, prefix string) ([]string, error) {
+	filters, err := json.Marshal(map[string][]string{"name": {prefix}})
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Volumes []struct {
+			Name string `json:"Name"`
+		} `json:"Volumes"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, "/volumes", url.Values{"filters": {string(filters)}}, nil, &list); err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, v := range list.Volumes {
+		// The name filter matches anywhere in the name
+		if strings.HasPrefix(v.Name, prefix) {
+			names = append(names, v.Name)
+		}
+	}
+	return names, nil
+}
+
+// RemoveVolume removes the named volume.
+func (c *Client) RemoveVolume(ctx context.Context, name string) error {
+	return c.doJSON(ctx, http.MethodDelete, "/volumes/"+name, nil, nil, nil)
+}
+
+// PruneVolumes removes the volumes starting with prefix, through client
+// when it is set and with the engine's CLI otherwise, and returns their
+// names. A volume used by a running container cannot be removed.
+func PruneVolumes(ctx context.Context, eng *engine.Engine, client *Client, prefix string) ([]string, error) {
+	if eng == nil {
+		eng = engine.Default()
+	}
+	var names []string
+	if client != nil {
+		var err error
+		if names, err = client.Volumes(ctx, prefix); err != nil {
+			return nil, fmt.Errorf("list volumes: %w", err)
+		}
+	} else {
+		out, err := eng.Command(ctx, "volume", "ls", "-q", "--filter", "name="+prefix).Output()
+		if err != nil {
+			return nil, fmt.Errorf("list volumes: %w", err)
+		}
+		sc := bufio.NewScanner(bytes.NewReader(out))
+		for sc.Scan() {
+			if name := strings.TrimSpace(sc.Text()); strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	var removed []string
+	for _, name := range names {
+		var err error
+		if client != nil {
+			err = client.RemoveVolume(ctx, name)
+		} else {
+			err = eng.Command(ctx, "volume", "rm", name).Run()
+		}
+		if err != nil {
+			return removed, fmt.Errorf("remove volume %s: %w", name, err)
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
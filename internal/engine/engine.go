@@ -0,0 +1,140 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Supported container engines.
+const (
+	Docker  = "docker"
+	Podman  = "podman"
+	Nerdctl = "nerdctl"
+)
+
+// Names lists the supported engine names.
+var Names = []string{Docker, Podman, Nerdctl}
+
+// Engine is a container CLI. Podman and nerdctl accept docker's command
+// line, so Engine only captures where they differ.
+type Engine struct {
+	Name string
+	// Binary is the executable to run; it defaults to Name.
+	Binary string
+	// Rootless reports whether the engine runs without root privileges.
+	Rootless bool
+}
+
+// New returns the engine with the given name; an empty name means docker.
+func New(name string) (*Engine, error) {
+	if name == "" {
+		name = Docker
+	}
+	for _, n := range Names {
+		if n == name {
+			return &Engine{Name: name, Binary: name, Rootless: os.Geteuid() != 0}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown container engine %q (expected one of %s)", name, strings.Join(Names, ", "))
+}
+
+// Default is the docker engine, used when no engine is configured.
+func Default() *Engine {
+	e, _ := New(Docker)
+	return e
+}
+
+// Command returns an exec.Cmd running the engine binary with args.
+func (e *Engine) Command(ctx context.Context, args ...string) *exec.Cmd {
+	// #nosec G204 - The binary is one of the known engines; arguments are built by callers from validated data
+	return exec.CommandContext(ctx, e.Binary, args...)
+}
+
+// Mount returns the value for a -v flag binding src to dst. Podman needs an
+// SELinux relabel: ":z" for directories shared by concurrent containers
+// (such as the workspace) and ":Z" for private ones.
+func (e *Engine) Mount(src, dst string, shared bool) string {
+	v := src + ":" + dst
+	if e.Name == Podman {
+		if shared {
+			return v + ":z"
+		}
+		return v + ":Z"
+	}
+	return v
+}
+
+// ReadOnlyMount returns the value for a -v flag binding src to dst
+// read-only. The mount may be shared by concurrent containers.
+func (e *Engine) ReadOnlyMount(src, dst string) string {
+	v := src + ":" + dst + ":ro"
+	if e.Name == Podman {
+		return v + ",z"
+	}
+	return v
+}
+
+// RunArgs returns extra flags for `run`. Rootless podman maps the invoking
+// user into the container so files written to mounts keep their owner.
+func (e *Engine) RunArgs() []string {
+	if e.Name == Podman && e.Rootless {
+		return []string{"--userns=keep-id"}
+	}
+	return nil
+}
+
+// BuildArgs returns extra flags for `build`. Podman defaults to OCI images,
+// which some registries reject and which drop HEALTHCHECK; build docker
+// format instead.
+func (e *Engine) BuildArgs() []string {
+	if e.Name == Podman {
+		return []string{"--format", "docker"}
+	}
+	return nil
+}
+
+// Ref returns the image reference to tag and push. Podman and nerdctl do
+// not assume Docker Hub for short names, so those are qualified with
+// docker.io.
+func (e *Engine) Ref(ref string) string {
+	if e.Name == Docker {
+		return ref
+	}
+	first, _, hasSlash := strings.Cut(ref, "/")
+	if !hasSlash {
+		return "docker.io/library/" + ref
+	}
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return ref
+	}
+	return "docker.io/" + ref
+}
+
+// LoginArgs returns the arguments to log in to registry, reading the
+// password from stdin.
+func (e *Engine) LoginArgs(registry, username string) []string {
+	args := []string{"login", "-u", username, "--password-stdin"}
+	if e.Name == Podman && (registry == "" || registry == "docker.io") {
+		// Podman has no default registry for login
+		registry = "docker.io"
+	}
+	if registry != "" {
+		args = append(args, registry)
+	}
+	return args
+}
+
+// CheckAvailable verifies that the engine is installed and its daemon or
+// service is reachable.
+func (e *Engine) CheckAvailable(ctx context.Context) error {
+	if _, err := exec.LookPath(e.Binary); err != nil {
+		return fmt.Errorf("%s is not installed: %w", e.Name, err)
+	}
+	if err := e.Command(ctx, "version").Run(); err != nil {
+		return fmt.Errorf("%s is not available or not running: %w", e.Name, err)
+	}
+	return nil
+}
@@ -4,21 +4,70 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 	"time"
 )
 
 type Logger struct {
-	json bool
-	mu   sync.Mutex
+	json    bool
+	mu      sync.Mutex
+	secrets []string
 }
 
 func New(jsonMode bool) *Logger { return &Logger{json: jsonMode} }
 
+// Mask is written in place of secret values.
+const Mask = "***"
+
+// Redact masks values wherever they appear in later log lines. Values
+// shorter than four characters are ignored, since masking them would
+// mangle unrelated output.
+func (l *Logger) Redact(values ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, v := range values {
+		if len(v) >= 4 && !contains(l.secrets, v) {
+			l.secrets = append(l.secrets, v)
+		}
+	}
+}
+
+// Scrub returns s with the values passed to Redact masked.
+func (l *Logger) Scrub(s string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.scrub(s)
+}
+
+func (l *Logger) scrub(s string) string {
+	for _, secret := range l.secrets {
+		s = strings.ReplaceAll(s, secret, Mask)
+	}
+	return s
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
 func (l *Logger) log(level, msg string, kv map[string]interface{}) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	if kv == nil { kv = map[string]interface{}{} }
+	if len(l.secrets) > 0 {
+		msg = l.scrub(msg)
+		for k, v := range kv {
+			if s := fmt.Sprint(v); l.scrub(s) != s {
+				kv[k] = l.scrub(s)
+			}
+		}
+	}
 	if l.json {
 		kv["level"] = level
 		kv["msg"] = msg
@@ -1,68 +1,358 @@
 package planner
 
 import (
+	"fmt"
+	"path/filepath"
 	"sort"
+	"strings"
 
 	"slick-autobuild/internal/config"
+	"slick-autobuild/internal/detect"
+	"slick-autobuild/internal/toolchain"
 )
 
 // Task represents a single build job after matrix expansion.
 type Task struct {
 	Path    string
-	Kind    string // dotnet or node (for MVP)
+	Kind    string // toolchain kind, e.g. dotnet or node
 	Version string // toolchain version (dotnet sdk version or node version)
+	// Variant is an extra matrix axis within a version, such as a .NET
+	// runtime identifier; empty for most toolchains.
+	Variant string
+	// DependsOn holds the IDs of tasks that must succeed before this one starts.
+	DependsOn []string
+	// Entry is the matrix entry the task came from, with inferred fields filled in.
+	Entry config.MatrixEntry
+	// Inferred lists the Entry fields that were detected rather than declared.
+	Inferred []string
 }
 
-// Plan is the final set of tasks.
+// ID uniquely identifies a task within a plan.
+func (t Task) ID() string {
+	if t.Variant != "" {
+		return fmt.Sprintf("%s:%s@%s/%s", t.Path, t.Kind, t.Version, t.Variant)
+	}
+	return fmt.Sprintf("%s:%s@%s", t.Path, t.Kind, t.Version)
+}
+
+// Plan is the final set of tasks, ordered so that every task comes after
+// the tasks it depends on.
 type Plan struct {
 	Tasks []Task
 }
 
-// Expand builds a plan from provided config and optional selection filter.
-func Expand(cfg *config.Root, selected map[string]struct{}) Plan {
-	var tasks []Task
+// Expand builds a plan from provided config and optional selection filter,
+// detecting project types relative to the current directory.
+func Expand(cfg *config.Root, selected map[string]struct{}) (Plan, error) {
+	return ExpandIn(".", cfg, selected)
+}
 
+// ExpandIn builds a plan for the workspace at root. Entries without a type
+// are detected from their files. Selected projects pull in their
+// dependencies transitively. Unknown dependencies, undetectable projects and
+// dependency cycles are reported as config errors.
+func ExpandIn(root string, cfg *config.Root, selected map[string]struct{}) (Plan, error) {
+	// Several entries can share a path, such as a .NET API and a Node.js
+	// frontend in one folder; the path depends on what any of them does
+	deps := map[string][]string{}
 	for _, m := range cfg.Matrix {
-		if len(selected) > 0 {
-			if _, ok := selected[m.Path]; !ok {
-				continue
+		deps[m.Path] = append(deps[m.Path], m.DependsOn...)
+	}
+	for _, m := range cfg.Matrix {
+		for _, dep := range m.DependsOn {
+			if _, ok := deps[dep]; !ok {
+				return Plan{}, fmt.Errorf("config error: %s depends on unknown project %s", m.Path, dep)
 			}
 		}
-		switch m.Type {
-		case "dotnet":
-			versions := m.Frameworks
-			if len(versions) == 0 {
-				versions = cfg.Runtime.Dotnet.Versions
+	}
+	if cycle := findCycle(cfg.Matrix); cycle != nil {
+		return Plan{}, fmt.Errorf("config error: dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	include := func(string) bool { return true }
+	if len(selected) > 0 {
+		closure := map[string]struct{}{}
+		var visit func(p string)
+		visit = func(p string) {
+			if _, ok := closure[p]; ok {
+				return
 			}
-			for _, v := range versions {
-				if v == "" {
-					continue
-				}
-				tasks = append(tasks, Task{Path: m.Path, Kind: "dotnet", Version: v})
+			closure[p] = struct{}{}
+			for _, dep := range deps[p] {
+				visit(dep)
 			}
-		case "node":
-			versions := m.NodeVersions
-			if len(versions) == 0 {
-				versions = cfg.Runtime.Node.Versions
+		}
+		for p := range selected {
+			if _, ok := deps[p]; ok {
+				visit(p)
 			}
-			for _, v := range versions {
-				if v == "" {
-					continue
-				}
-				tasks = append(tasks, Task{Path: m.Path, Kind: "node", Version: v})
+		}
+		include = func(p string) bool {
+			_, ok := closure[p]
+			return ok
+		}
+	}
+
+	var tasks []Task
+	// taskDeps holds the dependencies of each task's own entry
+	var taskDeps [][]string
+	byPath := map[string][]string{}
+	for _, m := range cfg.Matrix {
+		if !include(m.Path) {
+			continue
+		}
+		resolved, inferred, err := resolveEntry(root, withDefaults(cfg.Defaults, m))
+		if err != nil {
+			return Plan{}, err
+		}
+		if t := resolved.Test; t != nil && t.Enabled && t.Command == "" {
+			tc, ok := toolchain.Lookup(resolved.Type)
+			if _, canTest := tc.(toolchain.Tester); ok && !canTest {
+				return Plan{}, fmt.Errorf("config error: %s: %s projects have no default test command; set test.command", m.Path, resolved.Type)
+			}
+		}
+		for _, t := range expandEntry(cfg, resolved) {
+			t.Inferred = inferred
+			tasks = append(tasks, t)
+			taskDeps = append(taskDeps, m.DependsOn)
+			byPath[m.Path] = append(byPath[m.Path], t.ID())
+		}
+	}
+
+	for i := range tasks {
+		for _, dep := range taskDeps[i] {
+			tasks[i].DependsOn = append(tasks[i].DependsOn, byPath[dep]...)
+		}
+		sort.Strings(tasks[i].DependsOn)
+	}
+
+	sort.Slice(tasks, func(i, j int) bool { return less(tasks[i], tasks[j]) })
+
+	return Plan{Tasks: topoSort(tasks)}, nil
+}
+
+// withDefaults merges defaults.env, defaults.secrets, defaults.depCache and
+// defaults.runAsRoot into an entry. The entry's own settings win.
+func withDefaults(d config.DefaultSection, m config.MatrixEntry) config.MatrixEntry {
+	m.RunAsRoot = m.RunAsRoot || d.RunAsRoot
+	if d.DepCache != (config.DepCacheConfig{}) {
+		dc := d.DepCache
+		if m.DepCache != nil {
+			if m.DepCache.Mode != "" {
+				dc.Mode = m.DepCache.Mode
+			}
+			if m.DepCache.Dir != "" {
+				dc.Dir = m.DepCache.Dir
+			}
+		}
+		m.DepCache = &dc
+	}
+	if d.Sandbox != (config.SandboxConfig{}) {
+		sb := d.Sandbox
+		if m.Sandbox != nil {
+			if m.Sandbox.Network != "" {
+				sb.Network = m.Sandbox.Network
+			}
+			if m.Sandbox.CPUs != nil {
+				sb.CPUs = m.Sandbox.CPUs
+			}
+			if m.Sandbox.Memory != "" {
+				sb.Memory = m.Sandbox.Memory
+			}
+			if m.Sandbox.Pids != nil {
+				sb.Pids = m.Sandbox.Pids
+			}
+		}
+		m.Sandbox = &sb
+	}
+	if len(d.Env) > 0 {
+		env := make(map[string]string, len(d.Env)+len(m.Env))
+		for k, v := range d.Env {
+			env[k] = v
+		}
+		for k, v := range m.Env {
+			env[k] = v
+		}
+		m.Env = env
+	}
+	if len(d.Secrets) > 0 {
+		own := map[string]bool{}
+		for _, s := range m.Secrets {
+			own[s.Name] = true
+		}
+		var secrets []config.SecretConfig
+		for _, s := range d.Secrets {
+			if !own[s.Name] {
+				secrets = append(secrets, s)
+			}
+		}
+		m.Secrets = append(secrets, m.Secrets...)
+	}
+	return m
+}
+
+// resolveEntry fills in the type, package manager and build scripts of an
+// entry that does not declare a type, and reports which fields it inferred.
+// Typed java entries only get a missing package manager filled in, since
+// Maven and Gradle builds use different images; other typed entries keep
+// their toolchain's default package manager.
+func resolveEntry(root string, m config.MatrixEntry) (config.MatrixEntry, []string, error) {
+	if m.Type != "" {
+		if m.PackageManager != "" || m.Type != "java" {
+			return m, nil, nil
+		}
+		tc, ok := toolchain.Lookup(m.Type)
+		if !ok {
+			return m, nil, nil
+		}
+		if pt := tc.Detect(filepath.Join(root, m.Path)); pt != nil && pt.PackageManager != "" {
+			m.PackageManager = pt.PackageManager
+			return m, []string{"packageManager"}, nil
+		}
+		return m, nil, nil
+	}
+	pt := detect.InferProjectType(filepath.Join(root, m.Path))
+	if pt == nil {
+		return m, nil, fmt.Errorf("config error: cannot detect project type for %s; set type explicitly", m.Path)
+	}
+	inferred := []string{"type"}
+	m.Type = pt.Kind
+	if m.PackageManager == "" && pt.PackageManager != "" {
+		m.PackageManager = pt.PackageManager
+		inferred = append(inferred, "packageManager")
+	}
+	if len(m.BuildScripts) == 0 && len(pt.BuildScripts) > 0 {
+		m.BuildScripts = pt.BuildScripts
+		inferred = append(inferred, "buildScripts")
+	}
+	return m, inferred, nil
+}
+
+// expandEntry turns one matrix entry into a task per toolchain version and
+// variant. Entries of an unregistered type produce no tasks.
+func expandEntry(cfg *config.Root, m config.MatrixEntry) []Task {
+	tc, ok := toolchain.Lookup(m.Type)
+	if !ok {
+		return nil
+	}
+	var variants []string
+	vp, hasVariants := tc.(toolchain.VariantProvider)
+	if hasVariants {
+		variants = vp.Variants(m)
+	}
+	var tasks []Task
+	for _, v := range tc.Versions(cfg, m) {
+		if len(variants) == 0 {
+			tasks = append(tasks, Task{Path: m.Path, Kind: tc.Kind(), Version: v, Entry: m})
+			continue
+		}
+		for _, variant := range variants {
+			tasks = append(tasks, Task{Path: m.Path, Kind: tc.Kind(), Version: v, Variant: variant, Entry: vp.WithVariant(m, variant)})
+		}
+	}
+	return tasks
+}
+
+func less(a, b Task) bool {
+	if a.Path == b.Path {
+		if a.Kind == b.Kind {
+			if a.Version == b.Version {
+				return a.Variant < b.Variant
 			}
+			return a.Version < b.Version
 		}
+		return a.Kind < b.Kind
 	}
+	return a.Path < b.Path
+}
 
-	sort.Slice(tasks, func(i, j int) bool {
-		if tasks[i].Path == tasks[j].Path {
-			if tasks[i].Kind == tasks[j].Kind {
-				return tasks[i].Version < tasks[j].Version
+// topoSort orders tasks so dependencies come first, keeping the sorted
+// order among tasks that are ready at the same time.
+func topoSort(tasks []Task) []Task {
+	index := map[string]int{}
+	for i, t := range tasks {
+		index[t.ID()] = i
+	}
+	pending := make([]int, len(tasks))
+	dependents := make([][]int, len(tasks))
+	for i, t := range tasks {
+		for _, dep := range t.DependsOn {
+			j := index[dep]
+			pending[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	var ready []int
+	for i := range tasks {
+		if pending[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	ordered := make([]Task, 0, len(tasks))
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, tasks[i])
+		for _, d := range dependents[i] {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
 			}
-			return tasks[i].Kind < tasks[j].Kind
 		}
-		return tasks[i].Path < tasks[j].Path
-	})
+	}
+	return ordered
+}
+
+// findCycle returns the first dependency cycle among matrix entries as a
+// list of paths (first and last element equal), or nil if there is none.
+func findCycle(matrix []config.MatrixEntry) []string {
+	deps := map[string][]string{}
+	var paths []string
+	for _, m := range matrix {
+		if _, ok := deps[m.Path]; !ok {
+			paths = append(paths, m.Path)
+		}
+		deps[m.Path] = append(deps[m.Path], m.DependsOn...)
+	}
+	sort.Strings(paths)
 
-	return Plan{Tasks: tasks}
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	var stack []string
+	var visit func(p string) []string
+	visit = func(p string) []string {
+		state[p] = visiting
+		stack = append(stack, p)
+		for _, d := range deps[p] {
+			switch state[d] {
+			case visiting:
+				for i, s := range stack {
+					if s == d {
+						return append(append([]string{}, stack[i:]...), d)
+					}
+				}
+			case unvisited:
+				if c := visit(d); c != nil {
+					return c
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[p] = done
+		return nil
+	}
+	for _, p := range paths {
+		if state[p] == unvisited {
+			if c := visit(p); c != nil {
+				return c
+			}
+		}
+	}
+	return nil
 }
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package runner
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is not supported here; files are copied instead.
+func cloneFile(dst, src *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"slick-autobuild/internal/config"
+	"slick-autobuild/internal/engine"
+	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/toolchain"
+)
+
+// Supported values for the depCache mode.
+const (
+	DepCacheShared   = "shared"
+	DepCacheIsolated = "isolated"
+	DepCacheOff      = "off"
+)
+
+// DepCachePrefix starts the names of dependency cache volumes and host
+// directories, so they can be told apart when pruning.
+const DepCachePrefix = "slick-autobuild-deps-"
+
+// CacheBinds returns the -v style mounts for the package-manager caches the
+// task's toolchain keeps between runs, in a stable order. Each cache is a
+// named volume, or a directory under depCache.dir, named after the
+// toolchain, version and cache (and the project when caches are isolated).
+func CacheBinds(task planner.Task, eng *engine.Engine) ([]string, error) {
+	tc, ok := toolchain.Lookup(task.Kind)
+	if !ok {
+		return nil, nil
+	}
+	vp, ok := tc.(toolchain.VolumeProvider)
+	if !ok {
+		return nil, nil
+	}
+	var dc config.DepCacheConfig
+	if task.Entry.DepCache != nil {
+		dc = *task.Entry.DepCache
+	}
+	if dc.Mode == DepCacheOff {
+		return nil, nil
+	}
+	if eng == nil {
+		eng = engine.Default()
+	}
+
+	volumes := vp.Volumes(task.Entry, task.Version)
+	names := make([]string, 0, len(volumes))
+	for name := range volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	binds := make([]string, 0, len(names))
+	for _, name := range names {
+		parts := []string{task.Kind, task.Version, name}
+		if dc.Mode == DepCacheIsolated {
+			parts = append(parts, task.Path)
+		}
+		vol := DepCachePrefix + strings.Trim(invalidNameChars.ReplaceAllString(strings.Join(parts, "-"), "-"), "-")
+		if dc.Dir == "" {
+			binds = append(binds, vol+":"+volumes[name])
+			continue
+		}
+		dir, err := filepath.Abs(filepath.Join(dc.Dir, vol))
+		if err != nil {
+			return nil, fmt.Errorf("dependency cache dir: %w", err)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create dependency cache dir: %w", err)
+		}
+		// Concurrent builds of the same toolchain share the directory
+		binds = append(binds, eng.Mount(dir, volumes[name], true))
+	}
+	return binds, nil
+}
+
+// PruneDepDirs removes the dependency cache directories under dir and
+// returns their paths. Other files in dir are left alone.
+func PruneDepDirs(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), DepCachePrefix) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if err := os.RemoveAll(path); err != nil {
+			return removed, fmt.Errorf("remove %s: %w", path, err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
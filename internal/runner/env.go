@@ -0,0 +1,282 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"slick-autobuild/internal/engine"
+	"slick-autobuild/internal/logging"
+	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/toolchain"
+)
+
+// SecretsDir is where file secrets are mounted in build containers.
+const SecretsDir = "/run/secrets"
+
+// BuildEnv returns the task's env variables as sorted NAME=value pairs,
+// with "${VAR}" expanded from the host environment; unset variables expand
+// to "". Secrets are not included, so the result can go into cache keys.
+func BuildEnv(task planner.Task) []string {
+	names := make([]string, 0, len(task.Entry.Env))
+	for name := range task.Entry.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]string, 0, len(names))
+	for _, name := range names {
+		env = append(env, name+"="+expandHost(task.Entry.Env[name]))
+	}
+	return env
+}
+
+// toolchainEnv returns the variables the task's toolchain sets in build
+// containers, as sorted NAME=value pairs.
+func toolchainEnv(task planner.Task) []string {
+	tc, ok := toolchain.Lookup(task.Kind)
+	if !ok {
+		return nil
+	}
+	ep, ok := tc.(toolchain.EnvProvider)
+	if !ok {
+		return nil
+	}
+	vars := ep.Env(task.Entry, task.Version)
+	env := make([]string, 0, len(vars))
+	for name, value := range vars {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+var hostVarRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandHost replaces "${VAR}" with the host's value of VAR. A bare "$VAR"
+// is left for the build's shell.
+func expandHost(s string) string {
+	return hostVarRegex.ReplaceAllStringFunc(s, func(m string) string {
+		return os.Getenv(m[2 : len(m)-1])
+	})
+}
+
+// secret is a resolved secret: a value read from the host environment, or
+// a host file to mount.
+type secret struct {
+	name  string
+	value string
+	file  string
+}
+
+// buildEnv holds the variables and secrets passed to a task's build.
+type buildEnv struct {
+	vars    []string
+	secrets []secret
+}
+
+// resolveEnv reads the task's env and secrets from the host and registers
+// the secret values with the logger so they are masked in its output.
+func resolveEnv(task planner.Task, logger *logging.Logger) (buildEnv, error) {
+	env := buildEnv{vars: BuildEnv(task)}
+	for _, s := range task.Entry.Secrets {
+		if s.File != "" {
+			file, err := secretFile(s.File)
+			if err != nil {
+				return env, fmt.Errorf("secret %s: %w", s.Name, err)
+			}
+			// A single-line file is usually a token; mask it if it leaks
+			// #nosec G304 - Secret files are named by the config on purpose
+			if data, err := os.ReadFile(file); err == nil && len(data) <= 64<<10 {
+				if v := strings.TrimSpace(string(data)); !strings.Contains(v, "\n") {
+					logger.Redact(v)
+				}
+			}
+			env.secrets = append(env.secrets, secret{name: s.Name, file: file})
+			continue
+		}
+		src := s.Env
+		if src == "" {
+			src = s.Name
+		}
+		value, ok := os.LookupEnv(src)
+		if !ok {
+			return env, fmt.Errorf("secret %s: host variable %s is not set", s.Name, src)
+		}
+		logger.Redact(value)
+		env.secrets = append(env.secrets, secret{name: s.Name, value: value})
+	}
+	return env, nil
+}
+
+// secretFile returns the absolute path of a secret file, expanding a
+// leading "~/", and checks that it is a regular file.
+func secretFile(name string) (string, error) {
+	if rest, ok := strings.CutPrefix(name, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		name = filepath.Join(home, rest)
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", abs)
+	}
+	return abs, nil
+}
+
+// containerVars returns the variables that can be passed on the command
+// line: the env and the paths of mounted file secrets.
+func (e buildEnv) containerVars() []string {
+	vars := append([]string(nil), e.vars...)
+	for _, s := range e.secrets {
+		if s.file != "" {
+			vars = append(vars, s.name+"="+path.Join(SecretsDir, s.name))
+		}
+	}
+	return vars
+}
+
+// secretVars returns NAME=value for the secrets read from the environment.
+func (e buildEnv) secretVars() []string {
+	var vars []string
+	for _, s := range e.secrets {
+		if s.file == "" {
+			vars = append(vars, s.name+"="+s.value)
+		}
+	}
+	return vars
+}
+
+// secretBinds returns the read-only mounts of the file secrets.
+func (e buildEnv) secretBinds(eng *engine.Engine) []string {
+	var binds []string
+	for _, s := range e.secrets {
+		if s.file != "" {
+			binds = append(binds, eng.ReadOnlyMount(s.file, path.Join(SecretsDir, s.name)))
+		}
+	}
+	return binds
+}
+
+// nativeVars returns the variables of a native build, where file secrets
+// point at the host file.
+func (e buildEnv) nativeVars() []string {
+	vars := append([]string(nil), e.vars...)
+	for _, s := range e.secrets {
+		if s.file != "" {
+			vars = append(vars, s.name+"="+s.file)
+		} else {
+			vars = append(vars, s.name+"="+s.value)
+		}
+	}
+	return vars
+}
+
+// writeEnvFile writes the secret variables to a private env file for the
+// container CLI, so they do not show up in its arguments. The caller
+// removes the file.
+func (e buildEnv) writeEnvFile() (string, error) {
+	vars := e.secretVars()
+	if len(vars) == 0 {
+		return "", nil
+	}
+	var b strings.Builder
+	for _, v := range vars {
+		if strings.ContainsAny(v, "\r\n") {
+			name, _, _ := strings.Cut(v, "=")
+			return "", fmt.Errorf("secret %s spans several lines, which an env file cannot hold; use a file secret", name)
+		}
+		b.WriteString(v + "\n")
+	}
+	return writePrivate("slick-autobuild-env-", b.String())
+}
+
+// writeSecretScript writes the secret variables as a private shell script
+// of exports. Engine API builds mount it read-only at secretScript and
+// source it, since variables in the container spec show up in inspect. The
+// caller removes the file.
+func (e buildEnv) writeSecretScript() (string, error) {
+	var b strings.Builder
+	for _, s := range e.secrets {
+		if s.file == "" {
+			b.WriteString("export " + s.name + "='" + strings.ReplaceAll(s.value, "'", `'\''`) + "'\n")
+		}
+	}
+	if b.Len() == 0 {
+		return "", nil
+	}
+	return writePrivate("slick-autobuild-secrets-", b.String())
+}
+
+// secretScript is where Engine API builds mount the secret variables.
+const secretScript = "/run/slick-autobuild/secrets.sh"
+
+// writePrivate writes content to a new 0600 file. It prefers
+// $XDG_RUNTIME_DIR, which is a tmpfs on most systems, so the values never
+// reach the disk.
+func writePrivate(prefix, content string) (string, error) {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	f, err := os.CreateTemp(dir, prefix)
+	if err != nil {
+		return "", fmt.Errorf("create env file: %w", err)
+	}
+	// CreateTemp makes the file 0600
+	_, err = f.WriteString(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return "", fmt.Errorf("write env file: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// redactWriter masks secret values in build output. Output is held back
+// until a line ends so a value split across writes is still caught.
+type redactWriter struct {
+	out    io.Writer
+	logger *logging.Logger
+	buf    []byte
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	end := bytes.LastIndexAny(w.buf, "\r\n")
+	if end < 0 && len(w.buf) < 64<<10 {
+		return len(p), nil
+	}
+	if end < 0 {
+		end = len(w.buf) - 1
+	}
+	_, err := io.WriteString(w.out, w.logger.Scrub(string(w.buf[:end+1])))
+	w.buf = append(w.buf[:0], w.buf[end+1:]...)
+	return len(p), err
+}
+
+// Flush writes out any held-back output.
+func (w *redactWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w.out, w.logger.Scrub(string(w.buf)))
+	w.buf = w.buf[:0]
+	return err
+}
@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"slick-autobuild/internal/config"
+	"slick-autobuild/internal/docker"
+	"slick-autobuild/internal/planner"
+)
+
+// LockFile is the name of the image lock file, kept next to the config.
+const LockFile = "build.lock"
+
+// Image returns the task's build image: its toolchain's entry in
+// images.overrides with "{version}" replaced by the task's version, or the
+// toolchain's own image.
+func Image(task planner.Task, images config.ImagesConfig, pkgManager string, buildScripts []string) string {
+	if image, ok := images.Overrides[task.Kind]; ok {
+		return strings.ReplaceAll(image, "{version}", task.Version)
+	}
+	image, _ := DockerSpec(task, pkgManager, buildScripts)
+	return image
+}
+
+// CheckRegistry reports an image that does not come from one of the
+// allowed registries; an empty list allows any registry.
+func CheckRegistry(image string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	registry := docker.RegistryOf(image)
+	for _, a := range allowed {
+		if strings.EqualFold(a, registry) {
+			return nil
+		}
+	}
+	return fmt.Errorf("image %s comes from %s, which is not in images.allowedRegistries (%s)", image, registry, strings.Join(allowed, ", "))
+}
+
+// ImageLock pins build images to the registry digests they resolved to, so
+// later runs build with the same images even when their tags move.
+type ImageLock struct {
+	path string
+	// Images maps an image reference, such as node:20, to its digest.
+	Images map[string]string `json:"images"`
+	// refreshed holds the images resolved again during this run.
+	refreshed map[string]bool
+	changed   bool
+}
+
+// LoadImageLock reads the lock file at path; a missing file is an empty
+// lock.
+func LoadImageLock(path string) (*ImageLock, error) {
+	l := &ImageLock{path: path, Images: map[string]string{}, refreshed: map[string]bool{}}
+	// #nosec G304 - The lock file sits next to the config the user chose
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if l.Images == nil {
+		l.Images = map[string]string{}
+	}
+	return l, nil
+}
+
+// Pin returns image pinned to its digest as "image@sha256:..." along with
+// the digest. Locked images keep their digest unless update is set; others
+// are resolved with resolve and recorded. An image that names a digest
+// itself is returned as it is.
+func (l *ImageLock) Pin(ctx context.Context, image string, update bool, resolve func(context.Context, string) (string, error)) (string, string, error) {
+	if _, digest, ok := strings.Cut(image, "@"); ok {
+		return image, digest, nil
+	}
+	digest, ok := l.Images[image]
+	if !ok || (update && !l.refreshed[image]) {
+		var err error
+		if digest, err = resolve(ctx, image); err != nil {
+			return "", "", fmt.Errorf("resolve image %s: %w", image, err)
+		}
+		l.refreshed[image] = true
+		if l.Images[image] != digest {
+			l.Images[image] = digest
+			l.changed = true
+		}
+	}
+	return image + "@" + digest, digest, nil
+}
+
+// Save writes the lock file if Pin recorded a new digest.
+func (l *ImageLock) Save() error {
+	if !l.changed {
+		return nil
+	}
+	// Marshal sorts the map, so the file diffs cleanly
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", l.path, err)
+	}
+	l.changed = false
+	return nil
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/toolchain"
+)
+
+// Supported values for Options.Runtime.
+const (
+	RuntimeDocker = "docker"
+	RuntimeNative = "native"
+)
+
+// hostEnvAllowlist lists the host variables passed through to native builds.
+// Everything else (NODE_OPTIONS, npm_config_*, DOTNET_* ...) is dropped so
+// builds behave the same on every machine.
+var hostEnvAllowlist = []string{
+	"HOME", "USER", "LOGNAME", "SHELL", "LANG", "LC_ALL", "TERM",
+	"TMPDIR", "TEMP", "TMP",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+	"SystemRoot", "ComSpec", "PATHEXT", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// runNative runs the build command on the host with the toolchain version
+// resolved from the host's SDK installs.
+func runNative(ctx context.Context, task planner.Task, opts Options, benv buildEnv, workDir, script string, stdout, stderr io.Writer) error {
+	tc, ok := toolchain.Lookup(task.Kind)
+	if !ok {
+		return fmt.Errorf("unsupported task kind: %s", task.Kind)
+	}
+	nr, ok := tc.(toolchain.NativeResolver)
+	if !ok {
+		return fmt.Errorf("native runtime does not support %s projects; use --runtime docker", task.Kind)
+	}
+	env, err := nr.ResolveNative(task.Entry, task.Version)
+	if err != nil {
+		return fmt.Errorf("native runtime: %w", err)
+	}
+
+	shimDir, err := os.MkdirTemp("", "slick-autobuild-shims-")
+	if err != nil {
+		return fmt.Errorf("create shim dir: %w", err)
+	}
+	defer os.RemoveAll(shimDir)
+	for name, script := range env.Shims {
+		// #nosec G306 - Shims must be executable
+		if err := os.WriteFile(filepath.Join(shimDir, name), []byte(script), 0o700); err != nil {
+			return fmt.Errorf("write shim: %w", err)
+		}
+	}
+
+	opts.Logger.Debug("native run spec", map[string]interface{}{"path": env.Path, "cmd": script})
+
+	// #nosec G204 - Script is generated by the toolchain from validated config
+	cmd := exec.CommandContext(ctx, "bash", "-c", script)
+	cmd.Dir = workDir
+	cmd.Env = append(nativeEnviron(shimDir, env), benv.nativeVars()...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return interrupted(ctx, "native build")
+		}
+		return fmt.Errorf("native build failed: %w", err)
+	}
+	return nil
+}
+
+// nativeEnviron builds the isolated environment for a native build.
+func nativeEnviron(shimDir string, env *toolchain.NativeEnv) []string {
+	var out []string
+	for _, key := range hostEnvAllowlist {
+		if v, ok := os.LookupEnv(key); ok {
+			out = append(out, key+"="+v)
+		}
+	}
+	path := append([]string{shimDir}, env.Path...)
+	path = append(path, os.Getenv("PATH"))
+	out = append(out, "PATH="+strings.Join(path, string(os.PathListSeparator)))
+	return append(out, env.Env...)
+}
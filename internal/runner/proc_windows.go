@@ -0,0 +1,8 @@
+//go:build windows
+
+package runner
+
+import "os/exec"
+
+// setProcessGroup is a no-op on Windows; cancelling kills the shell only.
+func setProcessGroup(cmd *exec.Cmd) {}
@@ -2,28 +2,72 @@ package runner
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
+	"slick-autobuild/internal/artifact"
+	"slick-autobuild/internal/docker"
+	"slick-autobuild/internal/engine"
 	"slick-autobuild/internal/logging"
 	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/toolchain"
 )
 
 // Options configures task execution.
 type Options struct {
 	Logger        *logging.Logger
 	WorkspaceRoot string
+	// OutDir is where collected build outputs are copied to.
+	OutDir string
+	// ArtifactDir is the matrix entry's output dir override (relative to the project).
+	ArtifactDir string
+	// DefaultArtifactDir is the fallback from defaults.artifactDir.
+	DefaultArtifactDir string
+	// Runtime selects RuntimeDocker (default) or RuntimeNative.
+	Runtime string
+	// Engine is the container CLI used by RuntimeDocker; nil means docker.
+	Engine *engine.Engine
+	// Client runs build containers through the Docker Engine API instead of
+	// the engine's CLI.
+	Client *docker.Client
+	// Timeout limits each attempt; the build container is killed when it
+	// expires. Zero means no limit.
+	Timeout time.Duration
+	// Retries is how many times a failed attempt is run again, waiting
+	// RetryBackoff before the first retry and twice as long after each one.
+	Retries      int
+	RetryBackoff time.Duration
+	// User is the "uid:gid" build containers run as, usually HostUser(), so
+	// files written to the workspace belong to the invoking user. Empty, or
+	// runAsRoot on the entry, keeps the image's user.
+	User string
+	// Scratch is the copy of the project the task builds in; nil builds in
+	// the workspace itself.
+	Scratch *Scratch
+	// Sandbox limits the network access and resources of build containers,
+	// usually from ResolveSandbox.
+	Sandbox Sandbox
+	// Image overrides the toolchain's build image, usually with the result
+	// of Image pinned by an ImageLock.
+	Image string
 }
 
+// DefaultRetryBackoff is the wait before the first retry when
+// Options.RetryBackoff is not set.
+const DefaultRetryBackoff = 10 * time.Second
+
 // validateDockerImage ensures the Docker image name is safe
 func validateDockerImage(image string) error {
-	// Docker image names follow a specific format: [registry/]name[:tag]
+	// Docker image names follow a specific format: [registry[:port]/]name[:tag][@digest]
 	// Allow alphanumeric, dots, dashes, underscores, colons, and forward slashes
-	validImageRegex := regexp.MustCompile(`^[a-zA-Z0-9._/-]+:[a-zA-Z0-9._-]+$|^[a-zA-Z0-9._/-]+$`)
+	validImageRegex := regexp.MustCompile(`^[a-zA-Z0-9._-]+(:[0-9]+)?(/[a-zA-Z0-9._-]+)*(:[a-zA-Z0-9._-]+)?(@sha256:[a-f0-9]{64})?$`)
 	if !validImageRegex.MatchString(image) {
 		return fmt.Errorf("invalid Docker image name: %s", image)
 	}
@@ -39,76 +83,353 @@ func validatePath(path string) error {
 	return nil
 }
 
-// RunTask executes the given task using Docker to ensure toolchain isolation.
-// MVP: minimal commands, no caching yet.
-func RunTask(ctx context.Context, task planner.Task, opts Options, pkgManager string, buildScripts []string) error {
+// Result describes a task run.
+type Result struct {
+	// Steps holds the timing of each build step that ran.
+	Steps []artifact.Step
+	// Tests summarises the test stage; nil if it was off or wrote no results.
+	Tests *artifact.TestSummary
+	// Retries lists the failed attempts that were run again.
+	Retries []artifact.Retry
+}
+
+// RunTask executes the given task using Docker to ensure toolchain isolation,
+// or with host-installed SDKs when opts.Runtime is RuntimeNative. The
+// entry's env and secrets are read from the host first; secret values are
+// masked in the logger's output and the build's. Test result files are
+// copied into opts.OutDir even when the task fails. A failed or timed out
+// attempt is retried up to opts.Retries times unless ctx itself is done.
+func RunTask(ctx context.Context, task planner.Task, opts Options, pkgManager string, buildScripts []string) (Result, error) {
 	if opts.Logger == nil {
 		opts.Logger = logging.New(false)
 	}
+	backoff := opts.RetryBackoff
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+	env, err := resolveEnv(task, opts.Logger)
+	if err != nil {
+		return Result{}, err
+	}
+	var retries []artifact.Retry
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		if attempt > 1 && opts.Scratch != nil {
+			if err := opts.Scratch.Reset(); err != nil {
+				return Result{Retries: retries}, err
+			}
+		}
+		res, err := runAttempt(ctx, task, opts, env, pkgManager, buildScripts)
+		res.Retries = retries
+		if err == nil || ctx.Err() != nil || attempt > opts.Retries {
+			return res, err
+		}
+
+		retries = append(retries, artifact.Retry{Attempt: attempt, Error: err.Error(), DurationMs: time.Since(start).Milliseconds()})
+		opts.Logger.Warn("task attempt failed, retrying", map[string]interface{}{
+			"path": task.Path, "attempt": attempt, "retry_in": backoff.String(), "error": err,
+		})
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return res, interrupted(ctx, "build")
+		}
+		backoff *= 2
+	}
+}
+
+// runAttempt runs the task once, within opts.Timeout.
+func runAttempt(ctx context.Context, task planner.Task, opts Options, env buildEnv, pkgManager string, buildScripts []string) (Result, error) {
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
 	
 	// Validate workspace path
 	if err := validatePath(opts.WorkspaceRoot); err != nil {
-		return fmt.Errorf("invalid workspace root: %w", err)
+		return Result{}, fmt.Errorf("invalid workspace root: %w", err)
 	}
 	
-	workDir := filepath.Join(opts.WorkspaceRoot, task.Path)
+	workDir := projectDir(task, opts)
 	if _, err := os.Stat(workDir); err != nil {
-		return fmt.Errorf("task path missing: %s: %w", task.Path, err)
+		return Result{}, fmt.Errorf("task path missing: %s: %w", task.Path, err)
+	}
+
+	if len(task.Entry.PreBuild) > 0 || len(task.Entry.PostBuild) > 0 {
+		opts.Logger.Warn("running preBuild/postBuild hooks", map[string]interface{}{
+			"path":      task.Path,
+			"preBuild":  task.Entry.PreBuild,
+			"postBuild": task.Entry.PostBuild,
+		})
+	}
+	if opts.Runtime == RuntimeNative && task.Entry.Sandbox != nil {
+		opts.Logger.Warn("sandbox settings are not enforced by the native runtime", map[string]interface{}{"path": task.Path})
+	}
+
+	image, _ := DockerSpec(task, pkgManager, buildScripts)
+	if opts.Image != "" {
+		image = opts.Image
+	}
+	steps := BuildSteps(task, pkgManager, buildScripts)
+	tracker := newStepTracker(task, steps, opts.Logger)
+	var out, stderr io.Writer = os.Stdout, os.Stderr
+	if len(env.secrets) > 0 {
+		rout := &redactWriter{out: os.Stdout, logger: opts.Logger}
+		rerr := &redactWriter{out: os.Stderr, logger: opts.Logger}
+		defer func() {
+			_ = rout.Flush()
+			_ = rerr.Flush()
+		}()
+		out, stderr = rout, rerr
 	}
+	stdout := tracker.writer(out)
 
-	image, command := dockerSpec(task, pkgManager, buildScripts)
+	// Result files older than the run are left over from earlier builds;
+	// file times have a coarse resolution on some filesystems
+	started := time.Now().Add(-time.Second)
+	var err error
+	if opts.Runtime == RuntimeNative {
+		err = runNative(ctx, task, opts, env, workDir, stepScript(steps, 0), stdout, stderr)
+	} else {
+		for _, ph := range opts.Sandbox.phases(steps) {
+			err = runContainer(ctx, task, opts, env, image, ph.script(), ph.offline, stdout, stderr)
+			if err != nil {
+				break
+			}
+		}
+	}
+	_ = stdout.Flush()
+	res := Result{}
+	var last string
+	res.Steps, last = tracker.finish()
+
+	if testCommand(task, pkgManager) != "" && opts.OutDir != "" {
+		tests, collectErr := artifact.CollectTestResults(workDir, opts.OutDir, testResults(task), started)
+		if collectErr != nil {
+			opts.Logger.Warn("test result collection failed", map[string]interface{}{"path": task.Path, "error": collectErr})
+		}
+		res.Tests = tests
+		if tests != nil {
+			opts.Logger.Info("test results", map[string]interface{}{
+				"path": task.Path, "passed": tests.Passed, "failed": tests.Failed, "skipped": tests.Skipped,
+			})
+		}
+	}
+
+	if err != nil && last != "" {
+		err = fmt.Errorf("step %q: %w", last, err)
+		if last == testStep {
+			summary := "no result files found"
+			if res.Tests != nil {
+				summary = res.Tests.String()
+			}
+			err = fmt.Errorf("tests failed (%s): %w", summary, err)
+		}
+	}
+	return res, err
+}
+
+// runContainer runs script in a build container for the task, with the
+// limits of opts.Sandbox; offline cuts the container off the network.
+func runContainer(ctx context.Context, task planner.Task, opts Options, env buildEnv, image, script string, offline bool, stdout, stderr io.Writer) error {
+	if opts.Engine == nil {
+		opts.Engine = engine.Default()
+	}
 	
 	// Validate the Docker image name for security
 	if err := validateDockerImage(image); err != nil {
 		return fmt.Errorf("security check failed: %w", err)
 	}
+	// Podman and nerdctl do not resolve short names to Docker Hub
+	image = opts.Engine.Ref(image)
 	
-	opts.Logger.Debug("docker run spec", map[string]interface{}{"image": image, "cmd": command})
+	opts.Logger.Debug("docker run spec", map[string]interface{}{"engine": opts.Engine.Name, "image": image, "cmd": script})
+
+	// Name the container so it can be killed if the context is cancelled;
+	// killing the docker client alone leaves the container running.
+	name := containerName(task)
+	workDirInContainer := filepath.ToSlash(filepath.Join("/workspace", task.Path))
+	cacheBinds, err := CacheBinds(task, opts.Engine)
+	if err != nil {
+		return err
+	}
+	vars := toolchainEnv(task)
+	user := containerUser(task, opts)
+	if user != "" {
+		if err := prepareVolumes(ctx, task, opts, image, user, cacheBinds); err != nil {
+			return err
+		}
+		vars = append(vars, "HOME="+BuildHome)
+		script = homeSetup + script
+	}
+	vars = append(vars, env.containerVars()...)
+	if opts.Client != nil {
+		spec := docker.ContainerSpec{
+			Name:       name,
+			Image:      image,
+			Cmd:        []string{"bash", "-lc", script},
+			WorkingDir: workDirInContainer,
+			Env:        vars,
+			User:       user,
+			Binds:      append(workspaceBinds(task, opts), cacheBinds...),
+		}
+		spec.Binds = append(spec.Binds, env.secretBinds(opts.Engine)...)
+		// Source the secret variables from a mounted file rather than the
+		// spec's Env, which inspect shows to anyone who can reach the engine
+		secrets, err := env.writeSecretScript()
+		if err != nil {
+			return err
+		}
+		if secrets != "" {
+			defer os.Remove(secrets)
+			spec.Binds = append(spec.Binds, opts.Engine.ReadOnlyMount(secrets, secretScript))
+			spec.Cmd[2] = ". " + secretScript + " || exit $?\n" + script
+		}
+		opts.Sandbox.apply(&spec, offline)
+		code, err := opts.Client.Run(ctx, spec, stdout, stderr, opts.Logger)
+		if ctx.Err() != nil {
+			return interrupted(ctx, "docker build")
+		}
+		if err != nil {
+			return fmt.Errorf("docker build failed: %w", err)
+		}
+		if code != 0 {
+			return fmt.Errorf("docker build failed: exit status %d", code)
+		}
+		return nil
+	}
 
 	args := []string{
 		"run", "--rm",
-		"-v", fmt.Sprintf("%s:/workspace", opts.WorkspaceRoot),
-		"-w", filepath.ToSlash(filepath.Join("/workspace", task.Path)),
+		"--name", name,
+	}
+	args = append(args, opts.Engine.RunArgs()...)
+	binds := append(workspaceBinds(task, opts), cacheBinds...)
+	for _, bind := range append(binds, env.secretBinds(opts.Engine)...) {
+		args = append(args, "-v", bind)
+	}
+	if user != "" {
+		args = append(args, "--user", user)
+	}
+	args = append(args, opts.Sandbox.runArgs(offline)...)
+	for _, v := range vars {
+		args = append(args, "-e", v)
+	}
+	envFile, err := env.writeEnvFile()
+	if err != nil {
+		return err
+	}
+	if envFile != "" {
+		defer os.Remove(envFile)
+		args = append(args, "--env-file", envFile)
+	}
+	args = append(args,
+		"-w", workDirInContainer,
 		image,
-		"bash", "-lc", command,
+		"bash", "-lc", script,
+	)
+	cmd := opts.Engine.Command(ctx, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Cancel = func() error {
+		killContainer(opts.Engine, name)
+		return cmd.Process.Kill()
 	}
-	// #nosec G204 - Docker arguments are validated and constructed from controlled data
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return interrupted(ctx, "docker build")
+		}
 		return fmt.Errorf("docker build failed: %w", err)
 	}
 	return nil
 }
 
-func dockerSpec(task planner.Task, pkgManager string, buildScripts []string) (image string, command string) {
-	switch task.Kind {
-	case "dotnet":
-		image = "mcr.microsoft.com/dotnet/sdk:" + task.Version
-		// Basic restore + build
-		command = "dotnet restore && dotnet build -c Release"
-	case "node":
-		image = "node:" + task.Version
-		if pkgManager == "" {
-			pkgManager = "npm"
-		}
-		if len(buildScripts) == 0 {
-			buildScripts = []string{"build"}
-		}
-		// Single build script only (first) for MVP
-		buildCmd := buildScripts[0]
-		switch pkgManager {
-		case "pnpm":
-			command = fmt.Sprintf("corepack enable && pnpm install --frozen-lockfile || pnpm install && pnpm run %s", buildCmd)
-		case "yarn":
-			command = fmt.Sprintf("corepack enable && yarn install --frozen-lockfile || yarn install && yarn run %s", buildCmd)
-		default:
-			command = fmt.Sprintf("npm install && npm run %s", buildCmd)
-		}
-	default:
-		image = "alpine:latest"
-		command = "echo unsupported task kind"
-	}
-	return
+// interrupted describes a run stopped by ctx: a timeout or a cancellation.
+func interrupted(ctx context.Context, what string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s timed out: %w", what, ctx.Err())
+	}
+	return fmt.Errorf("%s cancelled: %w", what, ctx.Err())
+}
+
+// containerName returns a unique, docker-safe name for a task's build container.
+func containerName(task planner.Task) string {
+	var suffix [4]byte
+	_, _ = rand.Read(suffix[:])
+	safe := invalidNameChars.ReplaceAllString(task.Path+"-"+task.Kind+"-"+task.Version+"-"+task.Variant, "-")
+	return fmt.Sprintf("slick-autobuild-%s-%x", strings.Trim(safe, "-"), suffix)
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// killContainer force-stops a build container. It uses a fresh context since
+// the task's own context is already cancelled when this runs.
+func killContainer(eng *engine.Engine, name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_ = eng.Command(ctx, "kill", name).Run()
+}
+
+// DockerSpec returns the image and shell command used to build the task,
+// including its preBuild and postBuild hooks.
+func DockerSpec(task planner.Task, pkgManager string, buildScripts []string) (image string, command string) {
+	command = toolchain.JoinSteps(BuildSteps(task, pkgManager, buildScripts))
+	tc, ok := toolchain.Lookup(task.Kind)
+	if !ok {
+		return "alpine:latest", command
+	}
+	entry := task.Entry
+	entry.PackageManager = pkgManager
+	entry.BuildScripts = buildScripts
+	return tc.Image(entry, task.Version), command
+}
+
+// projectDir returns the directory the task builds in.
+func projectDir(task planner.Task, opts Options) string {
+	if opts.Scratch != nil {
+		return opts.Scratch.Dir
+	}
+	return filepath.Join(opts.WorkspaceRoot, task.Path)
+}
+
+// outputDirs returns the candidate build output directories for a task,
+// relative to the project directory, in order of preference.
+func outputDirs(task planner.Task, opts Options) []string {
+	if opts.ArtifactDir != "" {
+		return []string{opts.ArtifactDir}
+	}
+	var dirs []string
+	if tc, ok := toolchain.Lookup(task.Kind); ok {
+		dirs = tc.OutputDirs(task.Entry)
+	}
+	if opts.DefaultArtifactDir != "" {
+		dirs = append(dirs, opts.DefaultArtifactDir)
+	}
+	return dirs
+}
+
+// CollectArtifacts copies the task's build outputs from the workspace, or
+// from opts.Scratch, into opts.OutDir. The first candidate directory that exists wins; a build that
+// produced nothing is logged but not treated as an error.
+func CollectArtifacts(task planner.Task, opts Options) ([]string, error) {
+	if opts.Logger == nil {
+		opts.Logger = logging.New(false)
+	}
+	if opts.OutDir == "" {
+		return nil, nil
+	}
+	workDir := projectDir(task, opts)
+	for _, dir := range outputDirs(task, opts) {
+		collected, err := artifact.Collect(workDir, opts.OutDir, []string{dir})
+		if err != nil {
+			return nil, fmt.Errorf("collect artifacts: %w", err)
+		}
+		if len(collected) > 0 {
+			opts.Logger.Debug("artifacts collected", map[string]interface{}{"path": task.Path, "dir": dir, "out": opts.OutDir})
+			return collected, nil
+		}
+	}
+	opts.Logger.Warn("no build outputs found", map[string]interface{}{"path": task.Path, "candidates": outputDirs(task, opts)})
+	return nil, nil
 }
@@ -0,0 +1,179 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"slick-autobuild/internal/config"
+	"slick-autobuild/internal/docker"
+	"slick-autobuild/internal/toolchain"
+)
+
+// Supported values for the sandbox network.
+const (
+	NetworkDefault = "default"
+	NetworkNone    = "none"
+)
+
+// DefaultPidsLimit caps the processes of a build container when the
+// sandbox does not set pids.
+const DefaultPidsLimit = 4096
+
+// minDefaultMemory is the least memory a build gets by default, however
+// many run at once.
+const minDefaultMemory = 2 << 30
+
+// Sandbox is the network access and resource limits of a task's build
+// containers. The zero value adds no limits.
+type Sandbox struct {
+	// Network is NetworkNone to run only the dependency install steps
+	// online.
+	Network string
+	// CPUs, Memory (bytes) and Pids cap each container; zero means no
+	// limit.
+	CPUs   float64
+	Memory int64
+	Pids   int64
+}
+
+// ResolveSandbox returns the sandbox of an entry built concurrency tasks at
+// a time. Limits the entry leaves unset get an even share of the host: its
+// CPUs divided by concurrency, and its memory divided by concurrency but no
+// less than 2GiB. Pids defaults to DefaultPidsLimit.
+func ResolveSandbox(cfg *config.SandboxConfig, concurrency int) (Sandbox, error) {
+	var c config.SandboxConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sb := Sandbox{Network: c.Network, Pids: DefaultPidsLimit}
+	if c.CPUs != nil {
+		sb.CPUs = *c.CPUs
+	} else {
+		sb.CPUs = float64(runtime.NumCPU()) / float64(concurrency)
+	}
+	if c.Memory != "" {
+		mem, err := config.ParseSize(c.Memory)
+		if err != nil {
+			return sb, fmt.Errorf("sandbox memory: %w", err)
+		}
+		sb.Memory = mem
+	} else if total := hostMemory(); total > 0 {
+		sb.Memory = max(total/int64(concurrency), min(total, minDefaultMemory))
+	}
+	if c.Pids != nil {
+		sb.Pids = *c.Pids
+	}
+	return sb, nil
+}
+
+// hostMemory returns the host's total memory in bytes, or 0 where
+// /proc/meminfo is not available.
+func hostMemory() int64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// MemTotal:       16318480 kB
+		fields := strings.Fields(sc.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb << 10
+		}
+	}
+	return 0
+}
+
+// runArgs returns the engine CLI flags that apply the sandbox to a
+// container; offline cuts it off the network.
+func (s Sandbox) runArgs(offline bool) []string {
+	var args []string
+	if offline {
+		args = append(args, "--network", "none")
+	}
+	if s.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(s.CPUs, 'f', -1, 64))
+	}
+	if s.Memory > 0 {
+		args = append(args, "--memory", strconv.FormatInt(s.Memory, 10))
+	}
+	if s.Pids > 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(s.Pids, 10))
+	}
+	return args
+}
+
+// apply sets the sandbox on an Engine API container spec.
+func (s Sandbox) apply(spec *docker.ContainerSpec, offline bool) {
+	if offline {
+		spec.NetworkMode = "none"
+	}
+	spec.NanoCPUs = int64(s.CPUs * 1e9)
+	spec.Memory = s.Memory
+	spec.PidsLimit = s.Pids
+}
+
+// phase is a run of consecutive build steps that share one container.
+type phase struct {
+	// first is the index of the phase's first step in the build.
+	first   int
+	steps   []toolchain.Step
+	offline bool
+	// setup holds the commands of the setup steps of earlier phases, which
+	// run again ahead of this phase's steps.
+	setup []string
+}
+
+// script returns the phase's shell script.
+func (p phase) script() string {
+	var b strings.Builder
+	for _, cmd := range p.setup {
+		fmt.Fprintf(&b, "( %s\n) || exit $?\n", cmd)
+	}
+	return b.String() + stepScript(p.steps, p.first)
+}
+
+// phases splits a build's steps into container runs. With the network off,
+// each run of dependency install steps gets its own container with network
+// access and the steps around it run offline; otherwise all steps share
+// one container. Setup steps join the container of the step after them,
+// and run again in each later one.
+func (s Sandbox) phases(steps []toolchain.Step) []phase {
+	if s.Network != NetworkNone {
+		return []phase{{steps: steps}}
+	}
+	var out []phase
+	var setup []string
+	start := 0
+	for i, st := range steps {
+		if st.Setup && i < len(steps)-1 {
+			continue
+		}
+		run := steps[start : i+1]
+		if n := len(out); n > 0 && out[n-1].offline == !st.Network {
+			out[n-1].steps = append(out[n-1].steps, run...)
+		} else {
+			out = append(out, phase{first: start, steps: append([]toolchain.Step(nil), run...), offline: !st.Network, setup: setup})
+		}
+		for _, r := range run {
+			if r.Setup {
+				// Earlier phases keep their own copy
+				setup = append(append([]string(nil), setup...), r.Command)
+			}
+		}
+		start = i + 1
+	}
+	return out
+}
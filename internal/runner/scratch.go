@@ -0,0 +1,313 @@
+package runner
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/toolchain"
+)
+
+// Scratch is a private copy of a project for one task to build in, so
+// matrix cells of the same project never share node_modules, dist, bin or
+// obj, and the workspace itself is not written during the build.
+type Scratch struct {
+	// Root mirrors the workspace: the project is copied to Root/<path> and
+	// the entries around it are symlinks into the workspace, so native
+	// builds still find files outside the project by relative path.
+	Root string
+	// Dir is the copy of the project.
+	Dir string
+	// References lists the other projects, relative to the workspace, that
+	// were copied too because the build writes to them, such as .NET
+	// project references building into their own bin and obj.
+	References []string
+	workspace  string
+	path       string
+	outputs    []string
+	src        string
+}
+
+// NewScratch copies the task's project into a new directory under the
+// workspace's .buildcache/scratch, along with the projects it references.
+// Everything is copied except VCS metadata, node_modules and the build
+// cache at any depth, and the toolchain's output folders at each project's
+// root. Files are cloned copy-on-write where the filesystem allows, which
+// needs the copy on the same filesystem as the workspace.
+func NewScratch(workspaceRoot string, task planner.Task) (*Scratch, error) {
+	base := filepath.Join(workspaceRoot, ".buildcache", "scratch")
+	if err := os.MkdirAll(base, 0o755); err != nil {
+		return nil, fmt.Errorf("create scratch dir: %w", err)
+	}
+	root, err := os.MkdirTemp(base, strings.Trim(invalidNameChars.ReplaceAllString(task.ID(), "-"), "-")+"-")
+	if err != nil {
+		return nil, fmt.Errorf("create scratch dir: %w", err)
+	}
+	s := &Scratch{
+		Root:      root,
+		Dir:       filepath.Join(root, task.Path),
+		workspace: workspaceRoot,
+		path:      filepath.ToSlash(filepath.Clean(task.Path)),
+		src:       filepath.Join(workspaceRoot, task.Path),
+	}
+	tc, ok := toolchain.Lookup(task.Kind)
+	if ok {
+		for _, out := range tc.OutputDirs(task.Entry) {
+			s.outputs = append(s.outputs, filepath.ToSlash(out))
+		}
+	}
+	if rp, ok := tc.(toolchain.ReferenceProvider); ok && s.path != "." {
+		s.References = s.references(rp)
+	}
+	if s.path != "." {
+		s.link(workspaceRoot, root)
+	}
+	for _, rel := range append([]string{s.path}, s.References...) {
+		if err := s.populate(rel); err != nil {
+			_ = s.Remove()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// references follows the project's references, and theirs, to the other
+// projects in the workspace. Projects inside the task's own, or containing
+// it, are already part of the copy or its mirror and are left out.
+func (s *Scratch) references(rp toolchain.ReferenceProvider) []string {
+	var refs []string
+	overlaps := func(rel string) bool {
+		for _, other := range append([]string{s.path}, refs...) {
+			if within(rel, other) || within(other, rel) {
+				return true
+			}
+		}
+		return false
+	}
+	queue := rp.References(s.src)
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		rel, err := filepath.Rel(s.workspace, dir)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == ".." || strings.HasPrefix(rel, "../") || overlaps(rel) {
+			continue
+		}
+		refs = append(refs, rel)
+		queue = append(queue, rp.References(dir)...)
+	}
+	return refs
+}
+
+// within reports whether the slash-separated path rel is dir or inside it.
+func within(rel, dir string) bool {
+	return rel == dir || strings.HasPrefix(rel, dir+"/")
+}
+
+// link symlinks the entries of the workspace directory dir into mirror,
+// leaving out the build cache at the workspace root. It is best effort,
+// since Windows needs extra privileges for symlinks.
+func (s *Scratch) link(dir, mirror string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if dir == s.workspace && e.Name() == ".buildcache" {
+			continue
+		}
+		_ = os.Symlink(filepath.Join(dir, e.Name()), filepath.Join(mirror, e.Name()))
+	}
+}
+
+// populate copies the workspace directory rel into Root. The directories
+// leading to it become real directories too, with their other entries
+// symlinked to the workspace.
+func (s *Scratch) populate(rel string) error {
+	dir, mirror := s.workspace, s.Root
+	if rel != "." {
+		parts := strings.Split(rel, "/")
+		for i, part := range parts {
+			dir, mirror = filepath.Join(dir, part), filepath.Join(mirror, part)
+			if info, err := os.Lstat(mirror); err == nil && info.IsDir() {
+				continue
+			}
+			// Replace the symlink the parent's mirror holds
+			_ = os.Remove(mirror)
+			if err := os.Mkdir(mirror, 0o755); err != nil {
+				return fmt.Errorf("create scratch dir: %w", err)
+			}
+			if i < len(parts)-1 {
+				s.link(dir, mirror)
+			}
+		}
+	} else if err := os.MkdirAll(mirror, 0o755); err != nil {
+		return fmt.Errorf("create scratch dir: %w", err)
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		r, err := filepath.Rel(dir, p)
+		if err != nil || r == "." {
+			return err
+		}
+		if s.skipped(filepath.ToSlash(r), d) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(mirror, r), 0o755)
+		}
+		return copyEntry(p, filepath.Join(mirror, r), d)
+	})
+	if err != nil {
+		return fmt.Errorf("copy %s to scratch dir: %w", rel, err)
+	}
+	return nil
+}
+
+// skipped reports whether the copy leaves out rel, a path relative to a
+// project: VCS metadata, node_modules and the build cache at any depth, and
+// the toolchain's output folders at the project root.
+func (s *Scratch) skipped(rel string, d fs.DirEntry) bool {
+	switch d.Name() {
+	case ".git", "node_modules", ".buildcache":
+		return true
+	}
+	for _, out := range s.outputs {
+		if ok, _ := path.Match(out, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Reset replaces the copy with a fresh one, so a retried attempt does not
+// see what the failed one left behind.
+func (s *Scratch) Reset() error {
+	for _, rel := range append([]string{s.path}, s.References...) {
+		if err := os.RemoveAll(filepath.Join(s.Root, rel)); err != nil {
+			return fmt.Errorf("reset scratch dir: %w", err)
+		}
+		if err := s.populate(rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes the scratch directory.
+func (s *Scratch) Remove() error {
+	return os.RemoveAll(s.Root)
+}
+
+// copyBackLocks holds a mutex per project directory.
+var copyBackLocks sync.Map
+
+// CopyBack replaces the given outputs of the project in the workspace with
+// the ones built in the copy, so dependent tasks and local tools find them.
+// Nothing else in the workspace is written. Matrix cells of the same
+// project take turns; the last one to finish wins.
+func (s *Scratch) CopyBack(outputs []string) error {
+	mu, _ := copyBackLocks.LoadOrStore(s.src, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	for _, rel := range outputs {
+		if err := validatePath(rel); err != nil {
+			return err
+		}
+		from, to := filepath.Join(s.Dir, rel), filepath.Join(s.src, rel)
+		if _, err := os.Lstat(from); err != nil {
+			continue
+		}
+		if err := os.RemoveAll(to); err != nil {
+			return fmt.Errorf("copy back %s: %w", rel, err)
+		}
+		err := filepath.WalkDir(from, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			r, err := filepath.Rel(from, p)
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return os.MkdirAll(filepath.Join(to, r), 0o755)
+			}
+			return copyEntry(p, filepath.Join(to, r), d)
+		})
+		if err != nil {
+			return fmt.Errorf("copy back %s: %w", rel, err)
+		}
+	}
+	return nil
+}
+
+// copyEntry copies a regular file, cloning it where possible, or recreates
+// a symlink. Other file types are skipped.
+func copyEntry(from, to string, d fs.DirEntry) error {
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	if d.Type()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(from)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, to)
+	}
+	if !d.Type().IsRegular() {
+		return nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	// #nosec G304 - Copying files inside the workspace
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	// #nosec G304 - Destination is inside the scratch copy or the project
+	dst, err := os.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if cloneFile(dst, src) != nil {
+		_, err = io.Copy(dst, src)
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// workspaceBinds returns the mounts that make up /workspace in the build
+// container: the workspace itself, or with a scratch copy the workspace
+// read-only and the copies over the project and the projects it references.
+func workspaceBinds(task planner.Task, opts Options) []string {
+	if opts.Scratch == nil {
+		return []string{opts.Engine.Mount(opts.WorkspaceRoot, "/workspace", true)}
+	}
+	project := path.Join("/workspace", filepath.ToSlash(task.Path))
+	scratch := opts.Engine.Mount(opts.Scratch.Dir, project, false)
+	if project == "/workspace" {
+		return []string{scratch}
+	}
+	binds := []string{opts.Engine.ReadOnlyMount(opts.WorkspaceRoot, "/workspace"), scratch}
+	for _, rel := range opts.Scratch.References {
+		binds = append(binds, opts.Engine.Mount(filepath.Join(opts.Scratch.Root, rel), path.Join("/workspace", rel), false))
+	}
+	return binds
+}
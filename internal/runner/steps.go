@@ -0,0 +1,231 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"slick-autobuild/internal/artifact"
+	"slick-autobuild/internal/logging"
+	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/toolchain"
+)
+
+// stepMarker prefixes the lines the step script prints between steps. The
+// runner strips them from the output and uses them to time each step.
+const stepMarker = "::slick-autobuild-step::"
+
+// BuildSteps returns the steps that build the task, in order: the entry's
+// preBuild hooks, the toolchain's own steps, the postBuild hooks, then the
+// test stage if it is enabled.
+func BuildSteps(task planner.Task, pkgManager string, buildScripts []string) []toolchain.Step {
+	var steps []toolchain.Step
+	for i, hook := range task.Entry.PreBuild {
+		steps = append(steps, toolchain.Step{Name: fmt.Sprintf("preBuild %d", i+1), Command: hook})
+	}
+
+	tc, ok := toolchain.Lookup(task.Kind)
+	if !ok {
+		steps = append(steps, toolchain.Step{Name: "build", Command: "echo unsupported task kind"})
+	} else {
+		entry := task.Entry
+		entry.PackageManager = pkgManager
+		entry.BuildScripts = buildScripts
+		if st, ok := tc.(toolchain.Stepper); ok {
+			steps = append(steps, st.Steps(entry, task.Version)...)
+		} else {
+			steps = append(steps, toolchain.Step{Name: "build", Command: tc.Command(entry, task.Version)})
+		}
+	}
+
+	for i, hook := range task.Entry.PostBuild {
+		steps = append(steps, toolchain.Step{Name: fmt.Sprintf("postBuild %d", i+1), Command: hook})
+	}
+
+	if cmd := testCommand(task, pkgManager); cmd != "" {
+		steps = append(steps, toolchain.Step{Name: testStep, Command: cmd})
+	}
+	return steps
+}
+
+// testStep names the test stage among the build steps.
+const testStep = "test"
+
+// testCommand returns the command of the task's test stage, or "" when the
+// stage is off.
+func testCommand(task planner.Task, pkgManager string) string {
+	t := task.Entry.Test
+	if t == nil || !t.Enabled {
+		return ""
+	}
+	if t.Command != "" {
+		return t.Command
+	}
+	tc, ok := toolchain.Lookup(task.Kind)
+	if !ok {
+		return ""
+	}
+	tester, ok := tc.(toolchain.Tester)
+	if !ok {
+		return ""
+	}
+	entry := task.Entry
+	entry.PackageManager = pkgManager
+	return tester.TestCommand(entry, task.Version)
+}
+
+// testResults returns the result file globs of the task's test stage.
+func testResults(task planner.Task) []string {
+	if t := task.Entry.Test; t != nil && len(t.Results) > 0 {
+		return t.Results
+	}
+	if tc, ok := toolchain.Lookup(task.Kind); ok {
+		if tester, ok := tc.(toolchain.Tester); ok {
+			return tester.TestResults(task.Entry)
+		}
+	}
+	return nil
+}
+
+// stepScript returns a shell script that runs each step in a subshell,
+// printing a marker line before it and stopping at the first failure. The
+// markers number the steps from first, the index of steps[0] in the build.
+func stepScript(steps []toolchain.Step, first int) string {
+	var b strings.Builder
+	for i, s := range steps {
+		fmt.Fprintf(&b, "echo '%s%d'\n( %s\n) || exit $?\n", stepMarker, first+i, s.Command)
+	}
+	return b.String()
+}
+
+// stepTracker times steps from the markers in a build's output.
+type stepTracker struct {
+	task    planner.Task
+	steps   []toolchain.Step
+	logger  *logging.Logger
+	mu      sync.Mutex
+	current int
+	started time.Time
+	results []artifact.Step
+}
+
+func newStepTracker(task planner.Task, steps []toolchain.Step, logger *logging.Logger) *stepTracker {
+	return &stepTracker{task: task, steps: steps, logger: logger, current: -1}
+}
+
+// start finishes the running step and begins step i.
+func (t *stepTracker) start(i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finishLocked()
+	if i < 0 || i >= len(t.steps) {
+		return
+	}
+	t.current, t.started = i, time.Now()
+	t.logger.Info("build step started", map[string]interface{}{"path": t.task.Path, "step": t.steps[i].Name})
+}
+
+// finish ends the running step and returns the completed steps along with
+// the name of the last one, which is the failing step if the build failed.
+func (t *stepTracker) finish() ([]artifact.Step, string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last := ""
+	if t.current >= 0 {
+		last = t.steps[t.current].Name
+	}
+	t.finishLocked()
+	return t.results, last
+}
+
+func (t *stepTracker) finishLocked() {
+	if t.current < 0 {
+		return
+	}
+	s := t.steps[t.current]
+	elapsed := time.Since(t.started)
+	t.results = append(t.results, artifact.Step{Name: s.Name, Command: s.Command, DurationMs: elapsed.Milliseconds()})
+	t.logger.Info("build step finished", map[string]interface{}{"path": t.task.Path, "step": s.Name, "elapsed_ms": elapsed.Milliseconds()})
+	t.current = -1
+}
+
+// writer returns an io.Writer that passes output to out, handing marker
+// lines to the tracker instead.
+func (t *stepTracker) writer(out io.Writer) *markerWriter {
+	return &markerWriter{out: out, mark: t.start, bol: true}
+}
+
+// markerWriter filters marker lines out of a build's output. Only the
+// start of a line that could still be a marker is held back, so progress
+// output without newlines is not delayed.
+type markerWriter struct {
+	out  io.Writer
+	mark func(int)
+	bol  bool   // at the beginning of a line
+	line []byte // held-back start of the current line
+}
+
+func (w *markerWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		nl := bytes.IndexByte(p, '\n')
+		if !w.bol {
+			if nl < 0 {
+				_, err := w.out.Write(p)
+				return n, err
+			}
+			if _, err := w.out.Write(p[:nl+1]); err != nil {
+				return n, err
+			}
+			p = p[nl+1:]
+			w.bol = true
+			continue
+		}
+
+		chunk := p
+		if nl >= 0 {
+			chunk = p[:nl+1]
+		}
+		w.line = append(w.line, chunk...)
+		p = p[len(chunk):]
+		if nl >= 0 {
+			if i, ok := parseMarker(w.line); ok {
+				w.mark(i)
+			} else if _, err := w.out.Write(w.line); err != nil {
+				return n, err
+			}
+			w.line = w.line[:0]
+			continue
+		}
+		if !strings.HasPrefix(stepMarker, string(w.line)) && !bytes.HasPrefix(w.line, []byte(stepMarker)) {
+			if err := w.Flush(); err != nil {
+				return n, err
+			}
+			w.bol = false
+		}
+	}
+	return n, nil
+}
+
+// Flush writes out any held-back output.
+func (w *markerWriter) Flush() error {
+	if len(w.line) == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.line)
+	w.line = w.line[:0]
+	return err
+}
+
+func parseMarker(line []byte) (int, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, stepMarker) {
+		return 0, false
+	}
+	i, err := strconv.Atoi(strings.TrimPrefix(s, stepMarker))
+	return i, err == nil
+}
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"slick-autobuild/internal/docker"
+	"slick-autobuild/internal/planner"
+)
+
+// BuildHome is the HOME of build containers run as the invoking user, who
+// has no home directory in the image. The build script creates it first.
+const BuildHome = "/tmp/slick-autobuild-home"
+
+// homeSetup runs ahead of the build steps when the container runs as the
+// invoking user. It puts ~/.local/bin on PATH, where pip falls back to user
+// installs (poetry, uv) and corepack puts its shims, since the image's own
+// bin directories belong to root.
+const homeSetup = "mkdir -p \"$HOME/.local/bin\" || exit $?\n" +
+	"export PATH=\"$HOME/.local/bin:$PATH\"\n"
+
+// HostUser returns the invoking user as "uid:gid", or "" when it is root
+// or has no numeric IDs (Windows).
+func HostUser() string {
+	uid := os.Getuid()
+	if uid <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", uid, os.Getgid())
+}
+
+// containerUser returns the user to run the task's build container as, or
+// "" to keep the image's user.
+func containerUser(task planner.Task, opts Options) string {
+	if task.Entry.RunAsRoot {
+		return ""
+	}
+	return opts.User
+}
+
+// preparedVolumes records the cache volumes handed over to a user during
+// this run, keyed by "volume|uid:gid".
+var preparedVolumes sync.Map
+
+// prepareVolumes gives user the ownership of the named cache volumes among
+// binds, which the engine creates owned by root. It runs a short root
+// container from the build image, once per volume and run; only files not
+// already owned by user are changed. Host directories are skipped since
+// they are created by the invoking user.
+func prepareVolumes(ctx context.Context, task planner.Task, opts Options, image, user string, binds []string) error {
+	var mounts, dirs []string
+	for _, bind := range binds {
+		src, rest, _ := strings.Cut(bind, ":")
+		if strings.ContainsAny(src, `/\`) {
+			continue
+		}
+		if _, done := preparedVolumes.Load(src + "|" + user); done {
+			continue
+		}
+		dst, _, _ := strings.Cut(rest, ":")
+		mounts = append(mounts, bind)
+		dirs = append(dirs, dst)
+	}
+	if len(mounts) == 0 {
+		return nil
+	}
+
+	uid, gid, _ := strings.Cut(user, ":")
+	script := fmt.Sprintf(`find "$@" \( ! -user %s -o ! -group %s \) -exec chown -h %s {} +`, uid, gid, user)
+	cmd := append([]string{"sh", "-c", script, "sh"}, dirs...)
+	name := containerName(task) + "-setup"
+	opts.Logger.Debug("preparing cache volumes", map[string]interface{}{"path": task.Path, "volumes": mounts, "user": user})
+
+	var err error
+	if opts.Client != nil {
+		spec := docker.ContainerSpec{Name: name, Image: image, Cmd: cmd, User: "0:0", Binds: mounts}
+		var code int
+		code, err = opts.Client.Run(ctx, spec, io.Discard, os.Stderr, opts.Logger)
+		if err == nil && code != 0 {
+			err = fmt.Errorf("exit status %d", code)
+		}
+	} else {
+		args := append([]string{"run", "--rm", "--name", name, "--user", "0:0"}, opts.Engine.RunArgs()...)
+		for _, m := range mounts {
+			args = append(args, "-v", m)
+		}
+		args = append(append(args, image), cmd...)
+		c := opts.Engine.Command(ctx, args...)
+		c.Stderr = os.Stderr
+		err = c.Run()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return interrupted(ctx, "cache volume setup")
+		}
+		return fmt.Errorf("prepare cache volumes: %w", err)
+	}
+	for _, bind := range mounts {
+		src, _, _ := strings.Cut(bind, ":")
+		preparedVolumes.Store(src+"|"+user, true)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package toolchain
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"slick-autobuild/internal/config"
+)
+
+// dotnet builds, publishes or packs .NET projects with the SDK image.
+type dotnet struct{}
+
+func (dotnet) Kind() string { return "dotnet" }
+
+func (dotnet) Detect(dir string) *ProjectType {
+	if len(globAll(dir, "*.csproj", "*.fsproj", "*.vbproj", "*.sln")) == 0 {
+		return nil
+	}
+	return &ProjectType{Kind: "dotnet"}
+}
+
+func (dotnet) Versions(cfg *config.Root, entry config.MatrixEntry) []string {
+	return resolveVersions(cfg, entry, entry.Frameworks, "dotnet")
+}
+
+func (dotnet) LockFiles(projectDir string) []string {
+	// Project files and package lock files
+	return globAll(projectDir, "*.csproj", "*.fsproj", "*.vbproj", "packages.lock.json")
+}
+
+func (dotnet) Image(_ config.MatrixEntry, version string) string {
+	return "mcr.microsoft.com/dotnet/sdk:" + version
+}
+
+// References returns the directories of the projects referenced by the
+// project files in dir and below. Includes built from MSBuild properties
+// cannot be resolved and are skipped.
+func (dotnet) References(dir string) []string {
+	var refs []string
+	seen := map[string]bool{}
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			switch d.Name() {
+			case "bin", "obj", "node_modules", ".git":
+				if p != dir {
+					return filepath.SkipDir
+				}
+			}
+			return nil
+		}
+		switch filepath.Ext(p) {
+		case ".csproj", ".fsproj", ".vbproj":
+		default:
+			return nil
+		}
+		for _, include := range projectReferences(p) {
+			if strings.Contains(include, "$(") {
+				continue
+			}
+			ref := filepath.Dir(filepath.Join(filepath.Dir(p), filepath.FromSlash(strings.ReplaceAll(include, `\`, "/"))))
+			if !seen[ref] {
+				seen[ref] = true
+				refs = append(refs, ref)
+			}
+		}
+		return nil
+	})
+	return refs
+}
+
+// projectReferences returns the Include attributes of an MSBuild project's
+// ProjectReference items.
+func projectReferences(file string) []string {
+	// #nosec G304 - Reads project files of a scanned project directory
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+	var project struct {
+		ItemGroups []struct {
+			References []struct {
+				Include string `xml:"Include,attr"`
+			} `xml:"ProjectReference"`
+		} `xml:"ItemGroup"`
+	}
+	if xml.Unmarshal(data, &project) != nil {
+		return nil
+	}
+	var includes []string
+	for _, g := range project.ItemGroups {
+		for _, r := range g.References {
+			includes = append(includes, r.Include)
+		}
+	}
+	return includes
+}
+
+// Variants returns the entry's runtime identifiers.
+func (dotnet) Variants(entry config.MatrixEntry) []string {
+	if entry.Dotnet == nil {
+		return nil
+	}
+	return entry.Dotnet.RuntimeIdentifiers
+}
+
+// WithVariant narrows the entry to a single runtime identifier.
+func (dotnet) WithVariant(entry config.MatrixEntry, rid string) config.MatrixEntry {
+	d := *entry.Dotnet
+	d.RuntimeIdentifiers = []string{rid}
+	entry.Dotnet = &d
+	return entry
+}
+
+func (t dotnet) Command(entry config.MatrixEntry, version string) string {
+	return JoinSteps(t.Steps(entry, version))
+}
+
+// Steps restores packages, then builds, publishes or packs offline.
+func (dotnet) Steps(entry config.MatrixEntry, _ string) []Step {
+	d := dotnetSettings(entry)
+	target := ""
+	if file := d.Project + d.Solution; file != "" {
+		target = " " + shellQuote(filepath.ToSlash(file))
+	}
+	rid := ""
+	if len(d.RuntimeIdentifiers) > 0 {
+		rid = " -r " + shellQuote(d.RuntimeIdentifiers[0])
+	}
+
+	// Restore sees the same configuration and properties as the build, so
+	// the assets file matches what --no-restore expects
+	props := ""
+	names := make([]string, 0, len(d.Properties))
+	for name := range d.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		props += " " + shellQuote("-p:"+name+"="+d.Properties[name])
+	}
+
+	args := fmt.Sprintf("%s -c %s --no-restore%s", target, shellQuote(d.Configuration), rid)
+	restoreArgs := target + rid + " " + shellQuote("-p:Configuration="+d.Configuration)
+	if d.SelfContained != nil && d.Mode != "pack" {
+		args += fmt.Sprintf(" --self-contained %t", *d.SelfContained)
+		restoreArgs += fmt.Sprintf(" -p:SelfContained=%t", *d.SelfContained)
+	}
+	args += props
+	restore := Step{Name: "restore", Command: "dotnet restore" + restoreArgs + props, Network: true}
+	switch d.Mode {
+	case "publish":
+		return []Step{restore, {Name: "publish", Command: "dotnet publish" + args + " -o publish"}}
+	case "pack":
+		return []Step{restore, {Name: "pack", Command: "dotnet pack" + args + " -o nupkgs"}}
+	default:
+		return []Step{restore, {Name: "build", Command: "dotnet build" + args}}
+	}
+}
+
+func (dotnet) TestCommand(entry config.MatrixEntry, _ string) string {
+	d := dotnetSettings(entry)
+	target := ""
+	if file := d.Project + d.Solution; file != "" {
+		target = " " + shellQuote(filepath.ToSlash(file))
+	}
+	rid := ""
+	if len(d.RuntimeIdentifiers) > 0 {
+		rid = " -r " + shellQuote(d.RuntimeIdentifiers[0])
+	}
+	// Test what the build step compiled rather than building again
+	return fmt.Sprintf("dotnet test%s -c %s --no-build%s --logger trx --results-directory test-results", target, shellQuote(d.Configuration), rid)
+}
+
+func (dotnet) TestResults(config.MatrixEntry) []string {
+	return []string{filepath.Join("test-results", "*.trx")}
+}
+
+// Volumes keeps the NuGet package cache between runs.
+func (dotnet) Volumes(config.MatrixEntry, string) map[string]string {
+	return map[string]string{"nuget": CacheRoot + "/nuget"}
+}
+
+// Env points NuGet at the package cache volume.
+func (dotnet) Env(config.MatrixEntry, string) map[string]string {
+	return map[string]string{"NUGET_PACKAGES": CacheRoot + "/nuget"}
+}
+
+func (dotnet) OutputDirs(entry config.MatrixEntry) []string {
+	d := dotnetSettings(entry)
+	switch d.Mode {
+	case "publish":
+		return []string{"publish"}
+	case "pack":
+		return []string{"nupkgs"}
+	default:
+		return []string{filepath.Join("bin", d.Configuration)}
+	}
+}
+
+// dotnetSettings returns the entry's .NET settings with defaults applied.
+func dotnetSettings(entry config.MatrixEntry) config.DotnetConfig {
+	var d config.DotnetConfig
+	if entry.Dotnet != nil {
+		d = *entry.Dotnet
+	}
+	if d.Mode == "" {
+		d.Mode = "build"
+	}
+	if strings.TrimSpace(d.Configuration) == "" {
+		d.Configuration = "Release"
+	}
+	return d
+}
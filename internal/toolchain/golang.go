@@ -0,0 +1,88 @@
+package toolchain
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"slick-autobuild/internal/config"
+)
+
+// golang builds Go modules with the official golang image. Every main
+// package is built into bin/ with -trimpath so binaries are reproducible.
+type golang struct{}
+
+func (golang) Kind() string { return "go" }
+
+func (golang) Detect(dir string) *ProjectType {
+	if !hasFile(dir, "go.mod") {
+		return nil
+	}
+	return &ProjectType{Kind: "go"}
+}
+
+func (golang) Versions(cfg *config.Root, entry config.MatrixEntry) []string {
+	return resolveVersions(cfg, entry, entry.GoVersions, "go")
+}
+
+func (golang) LockFiles(projectDir string) []string {
+	return existing(projectDir, "go.mod", "go.sum", "go.work", "go.work.sum")
+}
+
+func (golang) Image(_ config.MatrixEntry, version string) string {
+	return "golang:" + version
+}
+
+func (g golang) Command(entry config.MatrixEntry, version string) string {
+	return JoinSteps(g.Steps(entry, version))
+}
+
+// Steps downloads the modules, then builds. The module cache is a volume,
+// so the build itself needs no network.
+func (golang) Steps(config.MatrixEntry, string) []Step {
+	return []Step{
+		{Name: "download", Command: "go mod download", Network: true},
+		{Name: "build", Command: "GOFLAGS=-trimpath go build -o bin/ ./..."},
+	}
+}
+
+// Volumes keeps the module cache between runs.
+func (golang) Volumes(config.MatrixEntry, string) map[string]string {
+	return map[string]string{"go-mod": CacheRoot + "/go-mod"}
+}
+
+// Env points the module cache at its volume.
+func (golang) Env(config.MatrixEntry, string) map[string]string {
+	return map[string]string{"GOMODCACHE": CacheRoot + "/go-mod"}
+}
+
+func (golang) OutputDirs(config.MatrixEntry) []string {
+	return []string{"bin"}
+}
+
+// GoVersion returns the Go version required by go.mod in dir, preferring
+// the toolchain directive over the go directive, or "" if there is none.
+func GoVersion(dir string) string {
+	// #nosec G304 - Reads go.mod from a project directory
+	f, err := os.Open(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var goVersion string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "toolchain":
+			return strings.TrimPrefix(fields[1], "go")
+		case "go":
+			goVersion = fields[1]
+		}
+	}
+	return goVersion
+}
@@ -0,0 +1,119 @@
+package toolchain
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"slick-autobuild/internal/config"
+)
+
+// java builds JVM projects with Maven or Gradle, preferring the project's
+// wrapper script when it has one.
+type java struct{}
+
+func (java) Kind() string { return "java" }
+
+func (java) Detect(dir string) *ProjectType {
+	switch {
+	case hasFile(dir, "pom.xml"):
+		return &ProjectType{Kind: "java", PackageManager: "maven"}
+	case hasFile(dir, "build.gradle"), hasFile(dir, "build.gradle.kts"):
+		return &ProjectType{Kind: "java", PackageManager: "gradle"}
+	}
+	return nil
+}
+
+func (java) Versions(cfg *config.Root, entry config.MatrixEntry) []string {
+	return resolveVersions(cfg, entry, entry.JavaVersions, "java")
+}
+
+func (java) LockFiles(projectDir string) []string {
+	files := existing(projectDir, "pom.xml",
+		"build.gradle", "build.gradle.kts", "settings.gradle", "settings.gradle.kts",
+		"gradle.properties", "gradle.lockfile",
+		filepath.Join("gradle", "libs.versions.toml"),
+		filepath.Join("gradle", "wrapper", "gradle-wrapper.properties"),
+		filepath.Join(".mvn", "wrapper", "maven-wrapper.properties"))
+	return append(files, globAll(projectDir, "*.gradle.lockfile")...)
+}
+
+func (java) Image(entry config.MatrixEntry, version string) string {
+	switch entry.PackageManager {
+	case "maven":
+		return "maven:3-eclipse-temurin-" + version
+	case "gradle":
+		return "gradle:jdk" + version
+	default:
+		return "eclipse-temurin:" + version
+	}
+}
+
+func (j java) Command(entry config.MatrixEntry, version string) string {
+	return JoinSteps(j.Steps(entry, version))
+}
+
+// Steps resolves the dependencies into the cache volumes, then builds.
+func (java) Steps(entry config.MatrixEntry, _ string) []Step {
+	return []Step{
+		{Name: "fetch", Command: javaTool(entry.PackageManager, "dependency:go-offline", "dependencies"), Network: true},
+		{Name: "build", Command: javaTool(entry.PackageManager, "package -DskipTests", "assemble")},
+	}
+}
+
+// javaTool runs a Maven goal or Gradle task with the entry's build tool,
+// preferring the project's wrapper script.
+func javaTool(pkgManager, goal, task string) string {
+	switch pkgManager {
+	case "gradle":
+		return fmt.Sprintf("if [ -x ./gradlew ]; then ./gradlew --no-daemon %s; else gradle --no-daemon %s; fi", task, task)
+	case "maven":
+		return fmt.Sprintf("if [ -x ./mvnw ]; then ./mvnw -B -ntp %s; else mvn -B -ntp %s; fi", goal, goal)
+	default:
+		// No build tool declared or detected: only wrappers can work in the plain JDK image
+		return fmt.Sprintf("if [ -x ./mvnw ]; then ./mvnw -B -ntp %s; else ./gradlew --no-daemon %s; fi", goal, task)
+	}
+}
+
+// Volumes keeps the Maven repository and wrapper downloads, or the Gradle
+// user home, between runs.
+func (java) Volumes(entry config.MatrixEntry, _ string) map[string]string {
+	return javaCaches(entry)
+}
+
+// Env points Maven and Gradle at the cache volumes.
+func (java) Env(entry config.MatrixEntry, _ string) map[string]string {
+	env := map[string]string{}
+	caches := javaCaches(entry)
+	if dir, ok := caches["maven"]; ok {
+		env["MAVEN_USER_HOME"] = dir
+		env["MAVEN_OPTS"] = "-Dmaven.repo.local=" + dir + "/repository"
+	}
+	if dir, ok := caches["gradle"]; ok {
+		env["GRADLE_USER_HOME"] = dir
+	}
+	return env
+}
+
+// javaCaches maps the cache names of the entry's build tool to their
+// directories.
+func javaCaches(entry config.MatrixEntry) map[string]string {
+	dir := func(name string) string { return CacheRoot + "/" + name }
+	switch entry.PackageManager {
+	case "maven":
+		return map[string]string{"maven": dir("maven")}
+	case "gradle":
+		return map[string]string{"gradle": dir("gradle")}
+	}
+	return map[string]string{"maven": dir("maven"), "gradle": dir("gradle")}
+}
+
+func (java) OutputDirs(entry config.MatrixEntry) []string {
+	switch entry.PackageManager {
+	case "gradle":
+		return []string{filepath.Join("build", "libs")}
+	case "maven":
+		return []string{filepath.Join("target", "*.[jw]ar")}
+	default:
+		return []string{filepath.Join("target", "*.[jw]ar"), filepath.Join("build", "libs")}
+	}
+}
@@ -0,0 +1,228 @@
+package toolchain
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"slick-autobuild/internal/config"
+)
+
+// NativeResolver is implemented by toolchains that can build with SDKs
+// installed on the host instead of inside a container image.
+type NativeResolver interface {
+	// ResolveNative locates the host installation of version. It returns an
+	// error naming the places it looked when the version is not installed.
+	ResolveNative(entry config.MatrixEntry, version string) (*NativeEnv, error)
+}
+
+// NativeEnv describes how to run a toolchain version installed on the host.
+type NativeEnv struct {
+	// Path lists directories to put in front of PATH.
+	Path []string
+	// Env holds extra KEY=VALUE variables.
+	Env []string
+	// Shims maps command names to shell scripts placed first on PATH, for
+	// tools that need an extra argument to select a version.
+	Shims map[string]string
+}
+
+// ResolveNative finds the requested .NET SDK via `dotnet --list-sdks` and
+// shims `dotnet` to run that SDK directly, so the host's newest SDK is not
+// silently used instead.
+func (dotnet) ResolveNative(_ config.MatrixEntry, version string) (*NativeEnv, error) {
+	bin, err := exec.LookPath("dotnet")
+	if err != nil {
+		return nil, fmt.Errorf("dotnet SDK %s requested but dotnet is not on PATH", version)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// #nosec G204 - Resolved dotnet binary with a fixed argument
+	out, err := exec.CommandContext(ctx, bin, "--list-sdks").Output()
+	if err != nil {
+		return nil, fmt.Errorf("dotnet --list-sdks: %w", err)
+	}
+
+	// Lines look like: 8.0.100 [/usr/share/dotnet/sdk]
+	sdkDirs := map[string]string{}
+	var installed []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		v := fields[0]
+		sdkDirs[v] = strings.Trim(fields[1], "[]")
+		installed = append(installed, v)
+	}
+	match := MatchVersion(version, installed)
+	if match == "" {
+		return nil, fmt.Errorf("dotnet SDK %s is not installed (dotnet --list-sdks found: %s)", version, listOrNone(installed))
+	}
+
+	sdk := filepath.Join(sdkDirs[match], match)
+	return &NativeEnv{
+		Env: []string{
+			"DOTNET_CLI_TELEMETRY_OPTOUT=1",
+			"DOTNET_NOLOGO=1",
+			"DOTNET_MSBUILD_SDK_RESOLVER_SDKS_DIR=" + filepath.Join(sdk, "Sdks"),
+			"DOTNET_MSBUILD_SDK_RESOLVER_SDKS_VER=" + match,
+		},
+		Shims: map[string]string{
+			"dotnet": fmt.Sprintf("#!/bin/sh\nexec %s %s \"$@\"\n",
+				shellQuote(bin), shellQuote(filepath.Join(sdk, "dotnet.dll"))),
+		},
+	}, nil
+}
+
+// ResolveNative finds the requested Node.js version in the install
+// directories of nvm, fnm, asdf and volta, then on PATH.
+func (node) ResolveNative(_ config.MatrixEntry, version string) (*NativeEnv, error) {
+	var searched []string
+	for _, m := range nodeManagers() {
+		searched = append(searched, m.name)
+		entries, err := os.ReadDir(m.root)
+		if err != nil {
+			continue
+		}
+		dirs := map[string]string{}
+		var installed []string
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			v := strings.TrimPrefix(e.Name(), "v")
+			dirs[v] = e.Name()
+			installed = append(installed, v)
+		}
+		if match := MatchVersion(version, installed); match != "" {
+			bin := filepath.Join(m.root, dirs[match], m.bin)
+			if hasFile(bin, nodeBinary()) {
+				return &NativeEnv{Path: []string{bin}}, nil
+			}
+		}
+	}
+
+	searched = append(searched, "PATH")
+	if bin, err := exec.LookPath("node"); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		// #nosec G204 - Resolved node binary with a fixed argument
+		out, err := exec.CommandContext(ctx, bin, "--version").Output()
+		if err == nil {
+			v := strings.TrimPrefix(strings.TrimSpace(string(out)), "v")
+			if MatchVersion(version, []string{v}) != "" {
+				return &NativeEnv{Path: []string{filepath.Dir(bin)}}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("node %s is not installed (looked in %s)", version, strings.Join(searched, ", "))
+}
+
+// nodeManager is a version manager's install directory layout.
+type nodeManager struct {
+	name string
+	root string // directory holding one subdirectory per version
+	bin  string // path from a version directory to its bin directory
+}
+
+func nodeManagers() []nodeManager {
+	home, _ := os.UserHomeDir()
+	env := func(key, fallback string) string {
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		return fallback
+	}
+	fnmDir := filepath.Join(home, ".local", "share", "fnm")
+	if runtime.GOOS == "darwin" {
+		fnmDir = filepath.Join(home, "Library", "Application Support", "fnm")
+	}
+	return []nodeManager{
+		{"nvm", filepath.Join(env("NVM_DIR", filepath.Join(home, ".nvm")), "versions", "node"), "bin"},
+		{"fnm", filepath.Join(env("FNM_DIR", fnmDir), "node-versions"), filepath.Join("installation", "bin")},
+		{"asdf", filepath.Join(env("ASDF_DATA_DIR", filepath.Join(home, ".asdf")), "installs", "nodejs"), "bin"},
+		{"volta", filepath.Join(env("VOLTA_HOME", filepath.Join(home, ".volta")), "tools", "image", "node"), "bin"},
+	}
+}
+
+func nodeBinary() string {
+	if runtime.GOOS == "windows" {
+		return "node.exe"
+	}
+	return "node"
+}
+
+// MatchVersion picks the installed version satisfying requested: an exact
+// match, or else the highest version that requested is a prefix of
+// (e.g. "20" or "20.11" matches "20.11.1"). It returns "" if none does.
+func MatchVersion(requested string, installed []string) string {
+	requested = strings.TrimPrefix(requested, "v")
+	var candidates []string
+	for _, v := range installed {
+		if v == requested {
+			return v
+		}
+		if strings.HasPrefix(v, requested+".") {
+			candidates = append(candidates, v)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Slice(candidates, func(i, j int) bool { return versionLess(candidates[i], candidates[j]) })
+	return candidates[len(candidates)-1]
+}
+
+var versionPart = regexp.MustCompile(`^(\d+)(.*)$`)
+
+// versionLess compares dotted versions numerically; a pre-release suffix
+// sorts before the release.
+func versionLess(a, b string) bool {
+	pa, pb := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		ma, mb := versionPart.FindStringSubmatch(pa[i]), versionPart.FindStringSubmatch(pb[i])
+		if ma == nil || mb == nil {
+			if pa[i] != pb[i] {
+				return pa[i] < pb[i]
+			}
+			continue
+		}
+		na, _ := strconv.Atoi(ma[1])
+		nb, _ := strconv.Atoi(mb[1])
+		if na != nb {
+			return na < nb
+		}
+		if ma[2] != mb[2] {
+			// "100" (release) sorts after "100-preview"
+			if ma[2] == "" || mb[2] == "" {
+				return ma[2] != ""
+			}
+			return ma[2] < mb[2]
+		}
+	}
+	return len(pa) < len(pb)
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
+
+// shellQuote quotes s for a POSIX shell, leaving plain words unquoted.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_:./@%+=-") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,196 @@
+package toolchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"slick-autobuild/internal/config"
+)
+
+// node builds JavaScript/TypeScript projects with npm, pnpm or yarn.
+type node struct{}
+
+func (node) Kind() string { return "node" }
+
+func (node) Detect(dir string) *ProjectType {
+	if !hasFile(dir, "package.json") {
+		return nil
+	}
+	pt := &ProjectType{Kind: "node"}
+
+	// Detect package manager
+	if hasFile(dir, "pnpm-lock.yaml") {
+		pt.PackageManager = "pnpm"
+	} else if hasFile(dir, "yarn.lock") {
+		pt.PackageManager = "yarn"
+	} else {
+		pt.PackageManager = "npm"
+	}
+
+	// Prefer what package.json declares over the lock file heuristics
+	pkg := readPackageJSON(dir)
+	if pm := packageManagerFromField(pkg.PackageManager); pm != "" {
+		pt.PackageManager = pm
+	}
+	pt.BuildScripts = buildScriptsFrom(pkg.Scripts)
+	return pt
+}
+
+func (node) Versions(cfg *config.Root, entry config.MatrixEntry) []string {
+	return resolveVersions(cfg, entry, entry.NodeVersions, "node")
+}
+
+func (node) LockFiles(projectDir string) []string {
+	return existing(projectDir, "package.json", "package-lock.json", "yarn.lock", "pnpm-lock.yaml")
+}
+
+func (node) Image(_ config.MatrixEntry, version string) string {
+	return "node:" + version
+}
+
+func (n node) Command(entry config.MatrixEntry, version string) string {
+	return JoinSteps(n.Steps(entry, version))
+}
+
+// corepackEnable installs the package manager shims next to node, or in
+// ~/.local/bin where that belongs to root, as in builds run as the invoking
+// user, which have ~/.local/bin on PATH.
+const corepackEnable = `{ corepack enable 2>/dev/null || corepack enable --install-directory "$HOME/.local/bin"; }`
+
+// Steps installs dependencies, then runs every build script in order.
+func (node) Steps(entry config.MatrixEntry, _ string) []Step {
+	pkgManager := entry.PackageManager
+	if pkgManager == "" {
+		pkgManager = "npm"
+	}
+	buildScripts := entry.BuildScripts
+	if len(buildScripts) == 0 {
+		buildScripts = []string{"build"}
+	}
+	var steps []Step
+	switch pkgManager {
+	case "pnpm", "yarn":
+		steps = []Step{
+			{Name: "corepack", Command: corepackEnable, Setup: true},
+			{Name: "install", Command: pkgManager + " install --frozen-lockfile || " + pkgManager + " install", Network: true},
+		}
+	default:
+		steps = []Step{{Name: "install", Command: "npm install", Network: true}}
+	}
+	for _, script := range buildScripts {
+		steps = append(steps, Step{Name: "run " + script, Command: fmt.Sprintf("%s run %s", pkgManager, shellQuote(script))})
+	}
+	return steps
+}
+
+// TestCommand runs the package's test script. The environment points the
+// usual JUnit reporters (jest-junit, mocha-junit-reporter) at test-results/.
+func (node) TestCommand(entry config.MatrixEntry, _ string) string {
+	pkgManager := entry.PackageManager
+	if pkgManager == "" {
+		pkgManager = "npm"
+	}
+	return "JEST_JUNIT_OUTPUT_DIR=test-results MOCHA_FILE=test-results/junit.xml " + pkgManager + " test"
+}
+
+func (node) TestResults(config.MatrixEntry) []string {
+	return []string{filepath.Join("test-results", "*.xml")}
+}
+
+// Volumes keeps the package manager's download cache, and the package
+// managers corepack fetches, between runs.
+func (node) Volumes(entry config.MatrixEntry, _ string) map[string]string {
+	return nodeCaches(entry)
+}
+
+// Env points the package manager and corepack at the cache volumes.
+func (node) Env(entry config.MatrixEntry, _ string) map[string]string {
+	caches := nodeCaches(entry)
+	env := map[string]string{}
+	for name, v := range map[string]string{
+		"npm":        "npm_config_cache",
+		"pnpm-store": "npm_config_store_dir",
+		"yarn":       "YARN_CACHE_FOLDER",
+		"yarn-berry": "YARN_GLOBAL_FOLDER",
+		"corepack":   "COREPACK_HOME",
+	} {
+		if dir, ok := caches[name]; ok {
+			env[v] = dir
+		}
+	}
+	return env
+}
+
+// nodeCaches maps the cache names of the entry's package manager to their
+// directories.
+func nodeCaches(entry config.MatrixEntry) map[string]string {
+	dir := func(name string) string { return CacheRoot + "/" + name }
+	switch entry.PackageManager {
+	case "pnpm":
+		return map[string]string{"pnpm-store": dir("pnpm-store"), "corepack": dir("corepack")}
+	case "yarn":
+		// Yarn 1 and Yarn 2+ keep their global caches apart
+		return map[string]string{"yarn": dir("yarn"), "yarn-berry": dir("yarn-berry"), "corepack": dir("corepack")}
+	}
+	return map[string]string{"npm": dir("npm")}
+}
+
+func (node) OutputDirs(config.MatrixEntry) []string {
+	return []string{"dist", "build"}
+}
+
+// packageJSON holds the package.json fields used for detection.
+type packageJSON struct {
+	PackageManager string            `json:"packageManager"`
+	Scripts        map[string]string `json:"scripts"`
+}
+
+// readPackageJSON parses package.json in projectPath; a missing or invalid
+// file yields the zero value.
+func readPackageJSON(projectPath string) packageJSON {
+	var pkg packageJSON
+	// #nosec G304 - Reads package.json from a scanned project directory
+	data, err := os.ReadFile(filepath.Join(projectPath, "package.json"))
+	if err != nil {
+		return pkg
+	}
+	_ = json.Unmarshal(data, &pkg)
+	return pkg
+}
+
+// packageManagerFromField maps the corepack "packageManager" field
+// (e.g. "pnpm@8.15.0") to a package manager name.
+func packageManagerFromField(field string) string {
+	name := strings.SplitN(field, "@", 2)[0]
+	switch name {
+	case "npm", "pnpm", "yarn":
+		return name
+	}
+	return ""
+}
+
+// buildScriptsFrom picks the build script from package.json scripts. It
+// falls back to "build" so projects without scripts still get a sensible
+// default.
+func buildScriptsFrom(scripts map[string]string) []string {
+	for _, name := range []string{"build", "build:prod", "compile"} {
+		if _, ok := scripts[name]; ok {
+			return []string{name}
+		}
+	}
+	var names []string
+	for name := range scripts {
+		if strings.HasPrefix(name, "build") {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return names[:1]
+	}
+	return []string{"build"}
+}
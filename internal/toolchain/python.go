@@ -0,0 +1,94 @@
+package toolchain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"slick-autobuild/internal/config"
+)
+
+// python builds wheels and sdists into dist/ with pip, poetry, uv or pipenv.
+type python struct{}
+
+func (python) Kind() string { return "python" }
+
+func (python) Detect(dir string) *ProjectType {
+	if !hasFile(dir, "pyproject.toml") && !hasFile(dir, "requirements.txt") && !hasFile(dir, "setup.py") {
+		return nil
+	}
+	pt := &ProjectType{Kind: "python", PackageManager: "pip"}
+	switch {
+	case hasFile(dir, "poetry.lock"):
+		pt.PackageManager = "poetry"
+	case hasFile(dir, "uv.lock"):
+		pt.PackageManager = "uv"
+	case hasFile(dir, "Pipfile.lock"):
+		pt.PackageManager = "pipenv"
+	}
+	return pt
+}
+
+func (python) Versions(cfg *config.Root, entry config.MatrixEntry) []string {
+	return resolveVersions(cfg, entry, entry.PythonVersions, "python")
+}
+
+func (python) LockFiles(projectDir string) []string {
+	files := existing(projectDir, "pyproject.toml", "setup.py", "setup.cfg",
+		"poetry.lock", "uv.lock", "Pipfile", "Pipfile.lock")
+	return append(files, globAll(projectDir, "requirements*.txt")...)
+}
+
+func (python) Image(_ config.MatrixEntry, version string) string {
+	return "python:" + version
+}
+
+func (p python) Command(entry config.MatrixEntry, version string) string {
+	return JoinSteps(p.Steps(entry, version))
+}
+
+// Steps installs the build tool with pip, then builds. Both steps keep
+// network access: the tools are installed into the image's own Python, and
+// the builds fetch their build backend into an isolated environment.
+func (python) Steps(entry config.MatrixEntry, _ string) []Step {
+	var install, build string
+	switch entry.PackageManager {
+	case "poetry":
+		install, build = "pip install --quiet poetry", "poetry build"
+	case "uv":
+		install, build = "pip install --quiet uv", "uv build"
+	case "pipenv":
+		// Build inside the locked environment so build backends see the
+		// pinned dependencies
+		install = "pip install --quiet pipenv && pipenv install --deploy && pipenv run pip install --quiet build"
+		build = "pipenv run python -m build --outdir dist ."
+	default:
+		// Packaged projects build a wheel and sdist; plain requirements.txt
+		// projects get wheels for their pinned dependencies.
+		install = "pip install --quiet build"
+		build = "if [ -f pyproject.toml ] || [ -f setup.py ]; then python -m build --outdir dist .; " +
+			"else pip wheel -r requirements.txt --wheel-dir dist; fi"
+	}
+	return []Step{
+		{Name: "install", Command: install, Network: true},
+		{Name: "build", Command: build, Network: true},
+	}
+}
+
+func (python) OutputDirs(config.MatrixEntry) []string {
+	return []string{"dist"}
+}
+
+// PythonVersion returns the version pinned by .python-version in dir, if any.
+func PythonVersion(dir string) string {
+	// #nosec G304 - Reads .python-version from a project directory
+	data, err := os.ReadFile(filepath.Join(dir, ".python-version"))
+	if err != nil {
+		return ""
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) == 0 {
+		return ""
+	}
+	return lines[0]
+}
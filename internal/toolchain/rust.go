@@ -0,0 +1,59 @@
+package toolchain
+
+import (
+	"path/filepath"
+
+	"slick-autobuild/internal/config"
+)
+
+// rust builds Cargo packages in release mode. The cargo registry and git
+// checkouts live in named volumes so crates are downloaded once.
+type rust struct{}
+
+func (rust) Kind() string { return "rust" }
+
+func (rust) Detect(dir string) *ProjectType {
+	if !hasFile(dir, "Cargo.toml") {
+		return nil
+	}
+	return &ProjectType{Kind: "rust", PackageManager: "cargo"}
+}
+
+func (rust) Versions(cfg *config.Root, entry config.MatrixEntry) []string {
+	return resolveVersions(cfg, entry, entry.RustVersions, "rust")
+}
+
+func (rust) LockFiles(projectDir string) []string {
+	return existing(projectDir, "Cargo.toml", "Cargo.lock", "rust-toolchain", "rust-toolchain.toml",
+		filepath.Join(".cargo", "config.toml"))
+}
+
+func (rust) Image(_ config.MatrixEntry, version string) string {
+	return "rust:" + version
+}
+
+func (r rust) Command(entry config.MatrixEntry, version string) string {
+	return JoinSteps(r.Steps(entry, version))
+}
+
+// Steps fetches the locked crates into the registry volumes, then builds.
+func (rust) Steps(config.MatrixEntry, string) []Step {
+	// target/release also holds deps, fingerprints and build scripts; copy
+	// only the top-level executables out for collection.
+	return []Step{
+		{Name: "fetch", Command: "cargo fetch --locked", Network: true},
+		{Name: "build", Command: "cargo build --release --locked && rm -rf target/bin && mkdir -p target/bin && " +
+			"find target/release -maxdepth 1 -type f -perm -u+x -exec cp -t target/bin/ {} +"},
+	}
+}
+
+func (rust) OutputDirs(config.MatrixEntry) []string {
+	return []string{filepath.Join("target", "bin")}
+}
+
+func (rust) Volumes(config.MatrixEntry, string) map[string]string {
+	return map[string]string{
+		"cargo-registry": "/usr/local/cargo/registry",
+		"cargo-git":      "/usr/local/cargo/git",
+	}
+}
@@ -0,0 +1,216 @@
+package toolchain
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"slick-autobuild/internal/config"
+)
+
+// Toolchain knows how to detect, version, key and build one kind of project.
+// Built-in toolchains register themselves in this package; internal
+// toolchains can be added with Register from any package imported by main.
+type Toolchain interface {
+	// Kind is the matrix entry type handled by this toolchain, e.g. "node".
+	Kind() string
+	// Detect reports the project in dir, or nil if it is not of this kind.
+	Detect(dir string) *ProjectType
+	// Versions resolves the toolchain versions a matrix entry builds against.
+	Versions(cfg *config.Root, entry config.MatrixEntry) []string
+	// LockFiles returns the files in projectDir that pin the build's inputs.
+	LockFiles(projectDir string) []string
+	// Image returns the container image used to build the entry with the
+	// given version.
+	Image(entry config.MatrixEntry, version string) string
+	// Command returns the shell command that builds the project, run from
+	// the project directory.
+	Command(entry config.MatrixEntry, version string) string
+	// OutputDirs lists the conventional build outputs, relative to the
+	// project, in order of preference. Entries may be glob patterns.
+	OutputDirs(entry config.MatrixEntry) []string
+}
+
+// VolumeProvider is implemented by toolchains that keep state, such as a
+// package registry, in named volumes between runs.
+type VolumeProvider interface {
+	// Volumes maps cache names to mount points in the build container. The
+	// runner turns each name into a volume or host directory keyed by
+	// toolchain and version, and by project if caches are isolated.
+	Volumes(entry config.MatrixEntry, version string) map[string]string
+}
+
+// EnvProvider is implemented by toolchains that set variables in the build
+// container, such as the cache locations their Volumes are mounted at.
+type EnvProvider interface {
+	Env(entry config.MatrixEntry, version string) map[string]string
+}
+
+// CacheRoot holds the cache volumes of toolchains whose tools are pointed at
+// them through EnvProvider. It is under /tmp so the tools can still create
+// the directories when caching is off and the build runs as a normal user.
+const CacheRoot = "/tmp/slick-autobuild-cache"
+
+// VariantProvider is implemented by toolchains that build each version
+// several times, such as .NET once per runtime identifier. Every variant
+// becomes its own task.
+type VariantProvider interface {
+	// Variants lists the entry's variants; none means a single build.
+	Variants(entry config.MatrixEntry) []string
+	// WithVariant returns the entry as built for one variant.
+	WithVariant(entry config.MatrixEntry, variant string) config.MatrixEntry
+}
+
+// ReferenceProvider is implemented by toolchains whose builds also write to
+// other projects, such as .NET project references, which build into their
+// own bin and obj.
+type ReferenceProvider interface {
+	// References returns the directories of the projects the project in
+	// dir references.
+	References(dir string) []string
+}
+
+// Stepper is implemented by toolchains whose build splits into separately
+// logged and timed steps. Command must equal JoinSteps of the steps.
+type Stepper interface {
+	Steps(entry config.MatrixEntry, version string) []Step
+}
+
+// JoinSteps chains step commands into the single command used for cache
+// keys and logs.
+func JoinSteps(steps []Step) string {
+	cmds := make([]string, len(steps))
+	for i, s := range steps {
+		cmds[i] = s.Command
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// Tester is implemented by toolchains with a default test command for the
+// test stage.
+type Tester interface {
+	// TestCommand runs the project's tests after the build.
+	TestCommand(entry config.MatrixEntry, version string) string
+	// TestResults lists the JUnit XML or TRX files the command writes, as
+	// globs relative to the project.
+	TestResults(entry config.MatrixEntry) []string
+}
+
+// Step is one named shell command of a build.
+type Step struct {
+	Name    string
+	Command string
+	// Network marks a step that fetches dependencies; it keeps network
+	// access when the entry's sandbox turns it off.
+	Network bool
+	// Setup marks a step that only prepares the container, such as
+	// installing shims. It needs no network, and runs again at the start of
+	// each later container when the sandbox splits the build.
+	Setup bool
+}
+
+// ProjectType represents the detected project type
+type ProjectType struct {
+	Kind           string   // toolchain kind, e.g. "dotnet", "node"
+	Frameworks     []string // For dotnet projects
+	PackageManager string   // For node projects
+	BuildScripts   []string // For node projects
+	Framework      string   // "angular", "next" or "vite" when recognised
+}
+
+var (
+	mu         sync.RWMutex
+	toolchains []Toolchain
+)
+
+// Register adds a toolchain to the registry, replacing any toolchain of the
+// same kind. Detection tries toolchains in registration order.
+func Register(tc Toolchain) {
+	mu.Lock()
+	defer mu.Unlock()
+	config.RegisterType(tc.Kind())
+	for i, existing := range toolchains {
+		if existing.Kind() == tc.Kind() {
+			toolchains[i] = tc
+			return
+		}
+	}
+	toolchains = append(toolchains, tc)
+}
+
+// Lookup returns the toolchain registered for kind.
+func Lookup(kind string) (Toolchain, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, tc := range toolchains {
+		if tc.Kind() == kind {
+			return tc, true
+		}
+	}
+	return nil, false
+}
+
+// All returns the registered toolchains in registration order.
+func All() []Toolchain {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Toolchain(nil), toolchains...)
+}
+
+func init() {
+	Register(dotnet{})
+	Register(node{})
+	Register(golang{})
+	Register(python{})
+	Register(java{})
+	Register(rust{})
+}
+
+// resolveVersions picks the entry's own versions, then the generic
+// per-entry override, then the runtime set for kind.
+func resolveVersions(cfg *config.Root, entry config.MatrixEntry, own []string, kind string) []string {
+	var versions []string
+	switch {
+	case len(own) > 0:
+		versions = own
+	case len(entry.Versions) > 0:
+		versions = entry.Versions
+	default:
+		versions = cfg.Runtime.Versions(kind)
+	}
+	var out []string
+	for _, v := range versions {
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// existing returns the candidates that exist in dir, as full paths.
+func existing(dir string, candidates ...string) []string {
+	var files []string
+	for _, name := range candidates {
+		if hasFile(dir, name) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	return files
+}
+
+// globAll returns the files in dir matching any of the patterns.
+func globAll(dir string, patterns ...string) []string {
+	var files []string
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		files = append(files, matches...)
+	}
+	return files
+}
+
+// hasFile checks if a file exists in the given directory
+func hasFile(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, name))
+	return err == nil
+}
@@ -10,15 +10,19 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"slick-autobuild/internal/artifact"
 	"slick-autobuild/internal/cache"
 	"slick-autobuild/internal/config"
+	"slick-autobuild/internal/detect"
 	"slick-autobuild/internal/docker"
+	"slick-autobuild/internal/engine"
 	"slick-autobuild/internal/logging"
 	"slick-autobuild/internal/planner"
 	"slick-autobuild/internal/runner"
+	"slick-autobuild/internal/toolchain"
 )
 
 // validatePath ensures the path is safe and doesn't contain path traversal attempts
@@ -44,6 +48,12 @@ var (
 	flagVersion     = flag.Bool("version", false, "Print version and exit")
 	flagNoDocker    = flag.Bool("no-docker", false, "Disable Docker image building")
 	flagPushImages  = flag.Bool("push-images", false, "Force push Docker images (overrides config)")
+	flagKeepGoing   = flag.Bool("keep-going", false, "Keep building remaining tasks after a failure")
+	flagMerge       = flag.Bool("merge", false, "init: add newly detected projects to an existing config")
+	flagRuntime     = flag.String("runtime", runner.RuntimeDocker, "Build runtime: docker or native (host-installed SDKs)")
+	flagEngine      = flag.String("engine", "", "Container engine: docker, podman or nerdctl (default: defaults.engine or docker)")
+	flagTimeout     = flag.Duration("timeout", 0, "Time limit per task attempt, e.g. 30m (overrides defaults.timeout)")
+	flagUpdateLock  = flag.Bool("update-lock", false, "Resolve build images again and record their new digests in build.lock")
 )
 
 // Error exit codes as defined in MVP
@@ -80,10 +90,25 @@ func main() {
 		if err := runBuild(); err != nil {
 			fatal(err)
 		}
+	case "init":
+		if err := runInit(); err != nil {
+			fatal(err)
+		}
+	case "validate":
+		if err := runValidate(); err != nil {
+			fatal(err)
+		}
 	case "clean":
 		if err := runClean(); err != nil {
 			fatal(err)
 		}
+	case "cache":
+		if len(args) < 2 || args[1] != "prune-deps" {
+			fatal(fmt.Errorf("cache command requires a subcommand: prune-deps"))
+		}
+		if err := runPruneDeps(); err != nil {
+			fatal(err)
+		}
 	case "version":
 		fmt.Println(version)
 	case "inspect":
@@ -106,12 +131,144 @@ func runPlan() error {
 
 	logger := logging.New(*flagJSON)
 	selected := parseOnly()
-	plan := planner.Expand(cfg, selected)
+	plan, err := planner.Expand(cfg, selected)
+	if err != nil {
+		return err
+	}
 	logger.Info("plan generated", map[string]interface{}{"tasks": len(plan.Tasks)})
 	printPlan(plan)
 	return nil
 }
 
+// Runtime versions written by init when a project does not pin one.
+const (
+	initDotnetVersion = "8.0"
+	initNodeVersion   = "20"
+	initGoVersion     = "1.22"
+	initPythonVersion = "3.12"
+	initJavaVersion   = "21"
+	initRustVersion   = "1"
+)
+
+// runInit scans the workspace for projects and writes a build.yaml, or with
+// --merge adds newly found projects to the existing one.
+func runInit() error {
+	logger := logging.New(*flagJSON)
+
+	projects, err := detect.Scan(".")
+	if err != nil {
+		return fmt.Errorf("scan workspace: %w", err)
+	}
+	if len(projects) == 0 {
+		return fmt.Errorf("init: no projects found")
+	}
+
+	var rt config.RuntimeConfig
+	var matrix []config.MatrixEntry
+	notes := map[string]string{}
+	addVersion := func(vs *config.VersionSet, v string) {
+		for _, existing := range vs.Versions {
+			if existing == v {
+				return
+			}
+		}
+		vs.Versions = append(vs.Versions, v)
+	}
+	for _, p := range projects {
+		me := config.MatrixEntry{Path: p.Path, Type: p.Kind}
+		note := "detected: " + p.Kind
+		switch p.Kind {
+		case "dotnet":
+			v := detect.DotnetSDKVersion(p.Path)
+			if v == "" {
+				v = initDotnetVersion
+			} else {
+				me.Frameworks = []string{v}
+			}
+			addVersion(&rt.Dotnet, v)
+		case "node":
+			v := detect.NodeVersion(p.Path)
+			if v == "" {
+				v = initNodeVersion
+			} else {
+				me.NodeVersions = []string{v}
+			}
+			addVersion(&rt.Node, v)
+			me.PackageManager = p.PackageManager
+			me.BuildScripts = p.BuildScripts
+			if p.Framework == "next" {
+				me.ArtifactDir = ".next"
+			}
+			if p.Framework != "" {
+				note += " (" + p.Framework + ")"
+			}
+			note += ", " + p.PackageManager
+		case "go":
+			v := toolchain.GoVersion(p.Path)
+			if v == "" {
+				v = initGoVersion
+			} else {
+				me.GoVersions = []string{v}
+			}
+			addVersion(&rt.Go, v)
+		case "python":
+			v := toolchain.PythonVersion(p.Path)
+			if v == "" {
+				v = initPythonVersion
+			} else {
+				me.PythonVersions = []string{v}
+			}
+			addVersion(&rt.Python, v)
+			me.PackageManager = p.PackageManager
+			note += ", " + p.PackageManager
+		case "rust":
+			addVersion(&rt.Rust, initRustVersion)
+		case "java":
+			addVersion(&rt.Java, initJavaVersion)
+			me.PackageManager = p.PackageManager
+			note += ", " + p.PackageManager
+		}
+		matrix = append(matrix, me)
+		notes[p.Path] = note
+	}
+
+	_, statErr := os.Stat(*flagConfig)
+	exists := statErr == nil
+	if exists && !*flagMerge {
+		return fmt.Errorf("%s already exists; use --merge to add newly detected projects", *flagConfig)
+	}
+
+	if exists {
+		added, err := config.Merge(*flagConfig, rt, matrix, notes)
+		if err != nil {
+			return err
+		}
+		logger.Info("config merged", map[string]interface{}{"config": *flagConfig, "added": added})
+		return nil
+	}
+
+	data, err := config.Generate(rt, matrix, notes)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*flagConfig, data, 0o644); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	logger.Info("config written", map[string]interface{}{"config": *flagConfig, "projects": len(matrix)})
+	return nil
+}
+
+// runValidate checks the config file without planning or building, for use
+// in pre-commit hooks and CI.
+func runValidate() error {
+	logger := logging.New(*flagJSON)
+	if err := config.Validate(*flagConfig); err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	logger.Info("config valid", map[string]interface{}{"config": *flagConfig})
+	return nil
+}
+
 func runBuild() error {
 	if *flagDryRun {
 		return runPlan()
@@ -122,7 +279,24 @@ func runBuild() error {
 	}
 	logger := logging.New(*flagJSON)
 	selected := parseOnly()
-	plan := planner.Expand(cfg, selected)
+	plan, err := planner.Expand(cfg, selected)
+	if err != nil {
+		return err
+	}
+	if *flagRuntime != runner.RuntimeDocker && *flagRuntime != runner.RuntimeNative {
+		return fmt.Errorf("config error: unknown runtime %q (expected docker or native)", *flagRuntime)
+	}
+	engineName := *flagEngine
+	if engineName == "" {
+		engineName = cfg.Defaults.Engine
+	}
+	eng, err := engine.New(engineName)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	// Docker is driven through its Engine API where the client can reach
+	// it; the client is only made when containers are used
+	var client *docker.Client
 	conc := *flagConcurrency
 	if conc <= 0 {
 		conc = runtime.NumCPU()
@@ -130,7 +304,9 @@ func runBuild() error {
 	logger.Info("starting builds", map[string]interface{}{"tasks": len(plan.Tasks), "concurrency": conc})
 
 	workspaceRoot, _ := os.Getwd()
-	ctx := context.Background()
+	// Cancelled on the first failure unless --keep-going is set
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Check if Docker is available for projects that need it (only if not disabled)
 	if !*flagNoDocker {
@@ -138,30 +314,32 @@ func runBuild() error {
 		registriesToLogin := make(map[string]bool)
 
 		for _, task := range plan.Tasks {
-			for _, me := range cfg.Matrix {
-				if me.Path == task.Path && me.Type == task.Kind && me.Docker != nil && me.Docker.Enabled {
-					hasDockerProjects = true
-					// Collect unique registries for login
-					registries := me.Docker.Registries
-					if len(registries) == 0 {
-						registriesToLogin["docker.io"] = true
-					} else {
-						for _, reg := range registries {
-							registriesToLogin[reg] = true
-						}
+			me := task.Entry
+			if me.Docker != nil && me.Docker.Enabled {
+				hasDockerProjects = true
+				// Collect unique registries for login
+				registries := me.Docker.Registries
+				if len(registries) == 0 {
+					registriesToLogin["docker.io"] = true
+				} else {
+					for _, reg := range registries {
+						registriesToLogin[reg] = true
 					}
 				}
 			}
 		}
 
 		if hasDockerProjects {
-			if err := docker.CheckDockerAvailable(ctx); err != nil {
-				return fmt.Errorf("Docker is required but not available: %w", err)
+			if client, err = docker.EngineClient(eng); err != nil {
+				return fmt.Errorf("config error: %w", err)
+			}
+			if err := docker.CheckDockerAvailable(ctx, eng, client); err != nil {
+				return fmt.Errorf("container engine is required but not available: %w", err)
 			}
 
 			// Login to registries if credentials are available
 			for registry := range registriesToLogin {
-				if err := docker.LoginToRegistry(ctx, registry, logger); err != nil {
+				if err := docker.LoginToRegistry(ctx, registry, logger, eng, client); err != nil {
 					logger.Warn("failed to login to registry", map[string]interface{}{
 						"registry": registry,
 						"error":    err,
@@ -171,54 +349,182 @@ func runBuild() error {
 		}
 	}
 
+	// Build containers run as the invoking user unless the engine is
+	// rootless and does that already
+	containerUser := ""
+	if *flagRuntime != runner.RuntimeNative {
+		if client == nil {
+			if client, err = docker.EngineClient(eng); err != nil {
+				return fmt.Errorf("config error: %w", err)
+			}
+		}
+		if !docker.MapsHostUser(ctx, eng, client) {
+			containerUser = runner.HostUser()
+		}
+	}
+
+	// Build images come from the allowed registries and are pinned to the
+	// digests in build.lock
+	images, err := resolveImages(ctx, plan, cfg, eng, client, logger)
+	if err != nil {
+		return err
+	}
+
+	// Each task records its outcome so dependents can wait on it
+	states := make(map[string]*taskState, len(plan.Tasks))
+	for _, t := range plan.Tasks {
+		states[t.ID()] = &taskState{done: make(chan struct{})}
+	}
+
 	sem := make(chan struct{}, conc)
-	errCh := make(chan error, len(plan.Tasks))
+	var wg sync.WaitGroup
 	for _, t := range plan.Tasks {
-		sem <- struct{}{}
+		wg.Add(1)
 		go func(task planner.Task) {
+			defer wg.Done()
+			state := states[task.ID()]
+			defer close(state.done)
+
+			fail := func(err error) {
+				// Tasks interrupted by an earlier failure count as skipped
+				if ctx.Err() != nil {
+					state.status = statusSkipped
+					return
+				}
+				state.status = statusFailed
+				state.err = err
+				if !*flagKeepGoing {
+					cancel()
+				}
+			}
+
+			// Wait for dependencies; their hashes feed this task's cache key
+			var depHashes []string
+			for _, dep := range task.DependsOn {
+				ds := states[dep]
+				<-ds.done
+				if ds.status != statusSucceeded {
+					logger.Warn("skipping build, dependency did not succeed", map[string]interface{}{"path": task.Path, "dependency": dep})
+					state.status = statusSkipped
+					return
+				}
+				depHashes = append(depHashes, dep+"="+ds.hash)
+			}
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				state.status = statusSkipped
+				return
+			}
 			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				state.status = statusSkipped
+				return
+			}
 			start := time.Now()
 
-			// Generate cache key
-			cacheKey, err := cache.Key(task, workspaceRoot)
+			// Extra fields from the (resolved) matrix entry
+			pkgMgr := task.Entry.PackageManager
+			scripts := task.Entry.BuildScripts
+			artifactDir := task.Entry.ArtifactDir
+			dockerCfg := task.Entry.Docker
+
+			// Generate cache key over sources plus the effective build command
+			_, command := runner.DockerSpec(task, pkgMgr, scripts)
+			image := images[task.ID()]
+			keyInputs := append([]string{image.ref, command, "runtime=" + *flagRuntime, "engine=" + eng.Name}, depHashes...)
+			// Secrets stay out of the key; rotating a token does not rebuild
+			for _, v := range runner.BuildEnv(task) {
+				keyInputs = append(keyInputs, "env:"+v)
+			}
+			cacheKey, err := cache.Key(task, workspaceRoot, keyInputs...)
 			if err != nil {
 				logger.Error("cache key generation failed", map[string]interface{}{"path": task.Path, "error": err})
-				errCh <- err
+				fail(err)
 				return
 			}
 
-			outDir := filepath.Join("out", task.Path, task.Version)
+			outDir := filepath.Join("out", task.Path, task.Version, task.Variant)
+
+			// Start from an empty output dir so stale files never end up in the cache
+			if err := os.RemoveAll(outDir); err != nil {
+				logger.Error("clean output dir failed", map[string]interface{}{"path": task.Path, "error": err})
+				fail(err)
+				return
+			}
 
 			// Check cache if not disabled
 			var reused bool
+			var artifacts []string
+			var steps []artifact.Step
+			var tests *artifact.TestSummary
+			var retries []artifact.Retry
 			if !*flagNoCache && cache.Exists(cacheKey) {
 				logger.Info("cache hit", map[string]interface{}{"path": task.Path, "key": cacheKey})
 				if err := cache.Restore(cacheKey, outDir); err != nil {
 					logger.Error("cache restore failed", map[string]interface{}{"path": task.Path, "error": err})
-					errCh <- err
+					fail(err)
 					return
 				}
+				if prev, err := artifact.ReadManifest(outDir); err == nil {
+					artifacts = prev.Artifacts
+					steps = prev.Steps
+					tests = prev.Tests
+				}
 				reused = true
 			} else {
-				logger.Info("build start", map[string]interface{}{"path": task.Path, "kind": task.Kind, "version": task.Version, "key": cacheKey})
-
-				// Find matrix entry for extra fields (package manager, build scripts, docker config)
-				var pkgMgr string
-				var scripts []string
-				var dockerCfg *config.DockerConfig
-				for _, me := range cfg.Matrix {
-					if me.Path == task.Path && me.Type == task.Kind {
-						pkgMgr = me.PackageManager
-						scripts = me.BuildScripts
-						dockerCfg = me.Docker
-						break
+				logger.Info("build start", map[string]interface{}{"path": task.Path, "kind": task.Kind, "version": task.Version, "variant": task.Variant, "key": cacheKey})
+
+				// Each task builds in its own copy of the project
+				scratch, err := runner.NewScratch(workspaceRoot, task)
+				if err != nil {
+					logger.Error("scratch copy failed", map[string]interface{}{"path": task.Path, "error": err})
+					fail(err)
+					return
+				}
+				defer func() {
+					if err := scratch.Remove(); err != nil {
+						logger.Warn("scratch cleanup failed", map[string]interface{}{"path": task.Path, "dir": scratch.Root, "error": err})
 					}
+				}()
+
+				runOpts := runner.Options{
+					Logger:             logger,
+					WorkspaceRoot:      workspaceRoot,
+					OutDir:             outDir,
+					ArtifactDir:        artifactDir,
+					DefaultArtifactDir: cfg.Defaults.ArtifactDir,
+					Runtime:            *flagRuntime,
+					Engine:             eng,
+					Client:             client,
+					User:               containerUser,
+					Scratch:            scratch,
+					Image:              image.ref,
 				}
-
-				runErr := runner.RunTask(ctx, task, runner.Options{Logger: logger, WorkspaceRoot: workspaceRoot}, pkgMgr, scripts)
+				runOpts.Timeout, runOpts.Retries, runOpts.RetryBackoff = retryPolicy(task.Entry, cfg.Defaults)
+				if runOpts.Sandbox, err = runner.ResolveSandbox(task.Entry.Sandbox, conc); err != nil {
+					logger.Error("build failed", map[string]interface{}{"path": task.Path, "error": err})
+					fail(err)
+					return
+				}
+				res, runErr := runner.RunTask(ctx, task, runOpts, pkgMgr, scripts)
+				steps, tests, retries = res.Steps, res.Tests, res.Retries
 				if runErr != nil {
 					logger.Error("build failed", map[string]interface{}{"path": task.Path, "error": runErr})
-					errCh <- runErr
+					fail(runErr)
+					return
+				}
+
+				artifacts, err = runner.CollectArtifacts(task, runOpts)
+				if err != nil {
+					logger.Error("artifact collection failed", map[string]interface{}{"path": task.Path, "error": err})
+					fail(err)
+					return
+				}
+				if err := scratch.CopyBack(artifacts); err != nil {
+					logger.Error("copying outputs to the workspace failed", map[string]interface{}{"path": task.Path, "error": err})
+					fail(err)
 					return
 				}
 
@@ -229,54 +535,161 @@ func runBuild() error {
 						dockerCfg.Push = true
 					}
 
-					imageBuilder := docker.NewImageBuilder(logger)
-					if err := imageBuilder.BuildAndPush(ctx, task.Path, dockerCfg, workspaceRoot); err != nil {
+					imageBuilder := docker.NewImageBuilder(logger, eng, client)
+					if err := imageBuilder.BuildAndPush(ctx, task.Path, dockerCfg, scratch.Root); err != nil {
 						logger.Error("Docker image build/push failed", map[string]interface{}{"path": task.Path, "error": err})
 						// Don't fail the entire build for Docker failures, just log warning
 						logger.Warn("continuing with build despite Docker failure", map[string]interface{}{"path": task.Path})
 					}
 				}
-
-				// Store in cache if not disabled
-				if !*flagNoCache {
-					if err := cache.Store(cacheKey, outDir); err != nil {
-						logger.Error("cache store failed", map[string]interface{}{"path": task.Path, "error": err})
-						// Don't fail the build for cache store failures
-					}
-				}
 			}
 
 			elapsed := time.Since(start)
-			_ = artifact.WriteManifest(outDir, artifact.Manifest{
+			if err := artifact.WriteManifest(outDir, artifact.Manifest{
 				Project:     task.Path,
 				Kind:        task.Kind,
 				Toolchain:   task.Kind,
 				Version:     task.Version,
+				Variant:     task.Variant,
 				Hash:        cacheKey,
+				Image:       image.ref,
+				ImageDigest: image.digest,
 				BuildTimeMs: elapsed.Milliseconds(),
 				Reused:      reused,
-			})
+				Artifacts:   artifacts,
+				Steps:       steps,
+				Tests:       tests,
+				Retries:     retries,
+			}); err != nil {
+				logger.Error("manifest write failed", map[string]interface{}{"path": task.Path, "error": err})
+				fail(err)
+				return
+			}
+
+			// Store in cache once outputs and manifest are in place
+			if !reused && !*flagNoCache {
+				if err := cache.Store(cacheKey, outDir); err != nil {
+					logger.Error("cache store failed", map[string]interface{}{"path": task.Path, "error": err})
+					// Don't fail the build for cache store failures
+				}
+			}
 
 			if reused {
 				logger.Info("build reused", map[string]interface{}{"path": task.Path, "elapsed_ms": elapsed.Milliseconds()})
 			} else {
 				logger.Info("build complete", map[string]interface{}{"path": task.Path, "elapsed_ms": elapsed.Milliseconds()})
 			}
+			state.hash = cacheKey
+			state.status = statusSucceeded
 		}(t)
 	}
-	for i := 0; i < cap(sem); i++ {
-		sem <- struct{}{}
-	}
-	close(errCh)
-	for e := range errCh {
-		if e != nil {
-			return errors.New("one or more builds failed")
-		}
+	wg.Wait()
+
+	if failed := summarize(plan, states, logger); failed > 0 {
+		return errors.New("one or more builds failed")
 	}
 	logger.Info("all tasks completed", nil)
 	return nil
 }
 
+// taskImage is the build image of a task and the digest it is pinned to;
+// the digest is empty for native builds.
+type taskImage struct {
+	ref    string
+	digest string
+}
+
+// resolveImages returns the build image of each task by ID, applying the
+// config's image overrides and registry allowlist. Container builds are
+// pinned to the digests in build.lock, next to the config; images missing
+// from it, or all of them with --update-lock, are resolved and recorded.
+func resolveImages(ctx context.Context, plan planner.Plan, cfg *config.Root, eng *engine.Engine, client *docker.Client, logger *logging.Logger) (map[string]taskImage, error) {
+	lock, err := runner.LoadImageLock(filepath.Join(filepath.Dir(*flagConfig), runner.LockFile))
+	if err != nil {
+		return nil, fmt.Errorf("config error: %w", err)
+	}
+	images := make(map[string]taskImage, len(plan.Tasks))
+	for _, task := range plan.Tasks {
+		ref := runner.Image(task, cfg.Images, task.Entry.PackageManager, task.Entry.BuildScripts)
+		if *flagRuntime == runner.RuntimeNative {
+			images[task.ID()] = taskImage{ref: ref}
+			continue
+		}
+		if err := runner.CheckRegistry(ref, cfg.Images.AllowedRegistries); err != nil {
+			return nil, fmt.Errorf("config error: %s: %w", task.ID(), err)
+		}
+		pinned, digest, err := lock.Pin(ctx, ref, *flagUpdateLock, func(ctx context.Context, ref string) (string, error) {
+			digest, err := docker.ImageDigest(ctx, eng, client, ref, logger)
+			if err == nil {
+				logger.Info("image locked", map[string]interface{}{"image": ref, "digest": digest})
+			}
+			return digest, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		images[task.ID()] = taskImage{ref: pinned, digest: digest}
+	}
+	if err := lock.Save(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// Task outcomes reported in the build summary.
+const (
+	statusSucceeded = "succeeded"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+)
+
+// taskState tracks a scheduled task's outcome. done is closed once the
+// task has finished, failed or been skipped.
+type taskState struct {
+	done   chan struct{}
+	status string
+	hash   string
+	err    error
+}
+
+// retryPolicy resolves a task's timeout and retries. The entry's own
+// settings win over --timeout, which wins over the defaults section.
+// Durations were checked when the config was loaded.
+func retryPolicy(entry config.MatrixEntry, defaults config.DefaultSection) (timeout time.Duration, retries int, backoff time.Duration) {
+	timeout, _ = time.ParseDuration(defaults.Timeout)
+	if *flagTimeout > 0 {
+		timeout = *flagTimeout
+	}
+	if entry.Timeout != "" {
+		timeout, _ = time.ParseDuration(entry.Timeout)
+	}
+	retries = defaults.Retries
+	if entry.Retries != nil {
+		retries = *entry.Retries
+	}
+	backoff, _ = time.ParseDuration(defaults.RetryBackoff)
+	return timeout, retries, backoff
+}
+
+// summarize logs which tasks succeeded, failed and were skipped, and returns
+// the number of failures.
+func summarize(plan planner.Plan, states map[string]*taskState, logger *logging.Logger) int {
+	byStatus := map[string][]string{}
+	for _, t := range plan.Tasks {
+		st := states[t.ID()]
+		byStatus[st.status] = append(byStatus[st.status], t.ID())
+		if st.status == statusFailed {
+			logger.Error("task failed", map[string]interface{}{"task": t.ID(), "error": st.err})
+		}
+	}
+	logger.Info("build summary", map[string]interface{}{
+		statusSucceeded: byStatus[statusSucceeded],
+		statusFailed:    byStatus[statusFailed],
+		statusSkipped:   byStatus[statusSkipped],
+	})
+	return len(byStatus[statusFailed])
+}
+
 func runClean() error {
 	logger := logging.New(*flagJSON)
 
@@ -299,6 +712,71 @@ func runClean() error {
 	return nil
 }
 
+// runPruneDeps removes the package-manager caches kept between container
+// builds: the named volumes, and the directories under any depCache.dir in
+// the config.
+func runPruneDeps() error {
+	logger := logging.New(*flagJSON)
+
+	// The config is optional here; it only supplies the engine and dirs
+	engineName := *flagEngine
+	var dirs []string
+	cfg, err := config.Load(*flagConfig)
+	switch {
+	case err == nil:
+		if engineName == "" {
+			engineName = cfg.Defaults.Engine
+		}
+		if cfg.Defaults.DepCache.Dir != "" {
+			dirs = append(dirs, cfg.Defaults.DepCache.Dir)
+		}
+		for _, m := range cfg.Matrix {
+			if m.DepCache != nil && m.DepCache.Dir != "" {
+				dirs = append(dirs, m.DepCache.Dir)
+			}
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	eng, err := engine.New(engineName)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	client, err := docker.EngineClient(eng)
+	if err != nil {
+		return fmt.Errorf("config error: %w", err)
+	}
+	ctx := context.Background()
+	if err := docker.CheckDockerAvailable(ctx, eng, client); err != nil {
+		return fmt.Errorf("container engine is required but not available: %w", err)
+	}
+	volumes, err := docker.PruneVolumes(ctx, eng, client, runner.DepCachePrefix)
+	if err != nil {
+		return err
+	}
+
+	removedDirs := []string{}
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		removed, err := runner.PruneDepDirs(dir)
+		removedDirs = append(removedDirs, removed...)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("dependency caches pruned", map[string]interface{}{
+		"volumes": len(volumes),
+		"dirs":    len(removedDirs),
+	})
+	return nil
+}
+
 func runInspect(key string) error {
 	logger := logging.New(*flagJSON)
 
@@ -348,6 +826,9 @@ func runInspect(key string) error {
 		fmt.Printf("  Kind: %s\n", manifest.Kind)
 		fmt.Printf("  Toolchain: %s\n", manifest.Toolchain)
 		fmt.Printf("  Version: %s\n", manifest.Version)
+		if manifest.Variant != "" {
+			fmt.Printf("  Variant: %s\n", manifest.Variant)
+		}
 		fmt.Printf("  Hash: %s\n", manifest.Hash)
 		fmt.Printf("  Build Time: %d ms\n", manifest.BuildTimeMs)
 		fmt.Printf("  Reused: %t\n", manifest.Reused)
@@ -375,8 +856,57 @@ func parseOnly() map[string]struct{} {
 func printPlan(p planner.Plan) {
 	fmt.Printf("Plan: %d task(s)\n", len(p.Tasks))
 	for _, t := range p.Tasks {
-		fmt.Printf(" - %s | kind=%s version=%s\n", t.Path, t.Kind, t.Version)
+		fmt.Printf(" - %s | kind=%s version=%s", t.Path, t.Kind, t.Version)
+		if t.Variant != "" {
+			fmt.Printf(" variant=%s", t.Variant)
+		}
+		if t.Entry.PackageManager != "" {
+			fmt.Printf(" packageManager=%s", t.Entry.PackageManager)
+		}
+		if len(t.Entry.BuildScripts) > 0 {
+			fmt.Printf(" buildScripts=%s", strings.Join(t.Entry.BuildScripts, ","))
+		}
+		if len(t.DependsOn) > 0 {
+			fmt.Printf(" dependsOn=%s", strings.Join(t.DependsOn, ","))
+		}
+		fmt.Println()
+		for _, hook := range t.Entry.PreBuild {
+			fmt.Printf("     preBuild: %s\n", hook)
+		}
+		for _, hook := range t.Entry.PostBuild {
+			fmt.Printf("     postBuild: %s\n", hook)
+		}
+		if len(t.Inferred) > 0 {
+			fmt.Printf("     declared: %s; inferred: %s\n", strings.Join(declaredFields(t), ", "), strings.Join(t.Inferred, ", "))
+		}
+	}
+}
+
+// declaredFields lists the plan-relevant entry fields set in the config
+// rather than inferred by the planner.
+func declaredFields(t planner.Task) []string {
+	inferred := map[string]bool{}
+	for _, f := range t.Inferred {
+		inferred[f] = true
+	}
+	declared := []string{"path"}
+	for _, f := range []string{"type", "packageManager", "buildScripts"} {
+		if inferred[f] {
+			continue
+		}
+		switch f {
+		case "packageManager":
+			if t.Entry.PackageManager == "" {
+				continue
+			}
+		case "buildScripts":
+			if len(t.Entry.BuildScripts) == 0 {
+				continue
+			}
+		}
+		declared = append(declared, f)
 	}
+	return declared
 }
 
 func fatal(err error) {
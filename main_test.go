@@ -1,14 +1,32 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
+	"slick-autobuild/internal/artifact"
 	"slick-autobuild/internal/cache"
 	"slick-autobuild/internal/config"
 	"slick-autobuild/internal/detect"
+	"slick-autobuild/internal/docker"
+	"slick-autobuild/internal/engine"
+	"slick-autobuild/internal/logging"
 	"slick-autobuild/internal/planner"
+	"slick-autobuild/internal/runner"
+	"slick-autobuild/internal/toolchain"
 )
 
 func TestConfigLoad(t *testing.T) {
@@ -31,6 +49,35 @@ func TestConfigLoad(t *testing.T) {
 	}
 }
 
+// validateYAML checks src as the contents of a config file.
+func validateYAML(t *testing.T, src string) error {
+	t.Helper()
+	return config.ValidateBytes("build.yaml", []byte(src))
+}
+
+func TestConfigValidate(t *testing.T) {
+	err := validateYAML(t, "matrix:\n  - path: web\n    type: nod\n    nodeVersion: [\"20\"]\n")
+	var verr *config.ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Expected validation error, got %v", err)
+	}
+	if len(verr.Problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %+v", verr.Problems)
+	}
+	if p := verr.Problems[0]; p.Line != 3 || p.Column != 11 || !strings.Contains(p.Message, "unknown type") {
+		t.Errorf("Unexpected first problem: %s", p)
+	}
+	if p := verr.Problems[1]; p.Line != 4 || !strings.Contains(p.Message, `did you mean "nodeVersions"`) {
+		t.Errorf("Unexpected second problem: %s", p)
+	}
+
+	// Runtime keys must name a toolchain
+	err = validateYAML(t, "runtime:\n  nodee:\n    versions: [\"20\"]\n")
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 || verr.Problems[0].Line != 2 || !strings.Contains(verr.Problems[0].Message, `unknown field "nodee" (did you mean "node"?)`) {
+		t.Errorf("Expected an unknown runtime key, got %v", err)
+	}
+}
+
 func TestPlannerExpand(t *testing.T) {
 	cfg := &config.Root{
 		Runtime: config.RuntimeConfig{
@@ -43,7 +90,10 @@ func TestPlannerExpand(t *testing.T) {
 		},
 	}
 	
-	plan := planner.Expand(cfg, map[string]struct{}{})
+	plan, err := planner.Expand(cfg, map[string]struct{}{})
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
 	
 	if len(plan.Tasks) != 2 {
 		t.Errorf("Expected 2 tasks, got %d", len(plan.Tasks))
@@ -60,6 +110,155 @@ func TestPlannerExpand(t *testing.T) {
 	}
 }
 
+func TestPlannerDependencies(t *testing.T) {
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{
+			Node: config.VersionSet{Versions: []string{"18.20.2", "20.11.1"}},
+		},
+		Matrix: []config.MatrixEntry{
+			{Path: "frontend", Type: "node", DependsOn: []string{"libs/ui"}},
+			{Path: "libs/ui", Type: "node"},
+		},
+	}
+	
+	// Selecting the app pulls in its library, which must be scheduled first
+	plan, err := planner.Expand(cfg, map[string]struct{}{"frontend": {}})
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
+	if len(plan.Tasks) != 4 {
+		t.Fatalf("Expected 4 tasks, got %d", len(plan.Tasks))
+	}
+	for i, task := range plan.Tasks {
+		if (i < 2) != (task.Path == "libs/ui") {
+			t.Errorf("Task %d out of dependency order: %+v", i, task)
+		}
+	}
+	if len(plan.Tasks[2].DependsOn) != 2 {
+		t.Errorf("Expected frontend to depend on both libs/ui tasks, got %+v", plan.Tasks[2].DependsOn)
+	}
+
+	// Entries sharing a path keep their own dependencies, and selecting the
+	// path pulls in those of every entry
+	shared := &config.Root{
+		Runtime: config.RuntimeConfig{
+			Node:   config.VersionSet{Versions: []string{"20.11.1"}},
+			Dotnet: config.VersionSet{Versions: []string{"8.0"}},
+		},
+		Matrix: []config.MatrixEntry{
+			{Path: "app", Type: "dotnet", DependsOn: []string{"libs/core"}},
+			{Path: "app", Type: "node", DependsOn: []string{"libs/ui"}},
+			{Path: "libs/core", Type: "dotnet"},
+			{Path: "libs/ui", Type: "node"},
+		},
+	}
+	plan, err = planner.Expand(shared, map[string]struct{}{"app": {}})
+	if err != nil || len(plan.Tasks) != 4 {
+		t.Fatalf("Expected both libraries selected with app, got %+v (%v)", plan.Tasks, err)
+	}
+	for _, task := range plan.Tasks {
+		if task.Path != "app" {
+			continue
+		}
+		want := map[string]string{"dotnet": "libs/core:dotnet@8.0", "node": "libs/ui:node@20.11.1"}[task.Kind]
+		if strings.Join(task.DependsOn, ",") != want {
+			t.Errorf("Expected %s to depend on %s, got %v", task.ID(), want, task.DependsOn)
+		}
+	}
+	
+	// Cycles are config errors
+	cfg.Matrix[1].DependsOn = []string{"frontend"}
+	if _, err := planner.Expand(cfg, nil); err == nil || !strings.Contains(err.Error(), "config error") {
+		t.Errorf("Expected dependency cycle config error, got %v", err)
+	}
+}
+
+func TestPlannerInferType(t *testing.T) {
+	tmpDir := t.TempDir()
+	webDir := filepath.Join(tmpDir, "web")
+	if err := os.MkdirAll(webDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(webDir, "package.json"), []byte(`{"name": "web"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(webDir, "yarn.lock"), []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{Node: config.VersionSet{Versions: []string{"20.11.1"}}},
+		Matrix:  []config.MatrixEntry{{Path: "web", BuildScripts: []string{"compile"}}},
+	}
+	plan, err := planner.ExpandIn(tmpDir, cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
+	if len(plan.Tasks) != 1 || plan.Tasks[0].Kind != "node" {
+		t.Fatalf("Expected one inferred node task, got %+v", plan.Tasks)
+	}
+	task := plan.Tasks[0]
+	if task.Entry.PackageManager != "yarn" || task.Entry.BuildScripts[0] != "compile" {
+		t.Errorf("Unexpected resolved entry: %+v", task.Entry)
+	}
+	if strings.Join(task.Inferred, ",") != "type,packageManager" {
+		t.Errorf("Expected type and packageManager to be inferred, got %v", task.Inferred)
+	}
+	
+	// Undetectable projects are config errors rather than silently dropped
+	cfg.Matrix = append(cfg.Matrix, config.MatrixEntry{Path: "missing"})
+	if _, err := planner.ExpandIn(tmpDir, cfg, nil); err == nil || !strings.Contains(err.Error(), "config error") {
+		t.Errorf("Expected config error for undetectable project, got %v", err)
+	}
+}
+
+// makeToolchain is a minimal toolchain used to exercise the registry.
+type makeToolchain struct{}
+
+func (makeToolchain) Kind() string { return "make" }
+func (makeToolchain) Detect(dir string) *toolchain.ProjectType {
+	if _, err := os.Stat(filepath.Join(dir, "Makefile")); err != nil {
+		return nil
+	}
+	return &toolchain.ProjectType{Kind: "make"}
+}
+func (makeToolchain) Versions(cfg *config.Root, entry config.MatrixEntry) []string {
+	if len(entry.Versions) > 0 {
+		return entry.Versions
+	}
+	return cfg.Runtime.Versions("make")
+}
+func (makeToolchain) LockFiles(dir string) []string               { return nil }
+func (makeToolchain) Image(_ config.MatrixEntry, v string) string  { return "internal/make:" + v }
+func (makeToolchain) Command(config.MatrixEntry, string) string    { return "make all" }
+func (makeToolchain) OutputDirs(config.MatrixEntry) []string       { return []string{"target"} }
+
+func TestToolchainRegistry(t *testing.T) {
+	toolchain.Register(makeToolchain{})
+	
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "Makefile"), []byte("all:\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{Other: map[string]config.VersionSet{"make": {Versions: []string{"4.4"}}}},
+		Matrix:  []config.MatrixEntry{{Path: "."}},
+	}
+	plan, err := planner.ExpandIn(tmpDir, cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
+	if len(plan.Tasks) != 1 || plan.Tasks[0].Kind != "make" || plan.Tasks[0].Version != "4.4" {
+		t.Fatalf("Expected one detected make task, got %+v", plan.Tasks)
+	}
+	
+	image, command := runner.DockerSpec(plan.Tasks[0], "", nil)
+	if image != "internal/make:4.4" || command != "make all" {
+		t.Errorf("Unexpected docker spec: %s %s", image, command)
+	}
+}
+
 func TestCacheKey(t *testing.T) {
 	task := planner.Task{
 		Path:    "test/project",
@@ -90,6 +289,58 @@ func TestCacheKey(t *testing.T) {
 	}
 }
 
+func TestCacheKeySources(t *testing.T) {
+	task := planner.Task{
+		Path:    "web",
+		Kind:    "node",
+		Version: "20.11.1",
+	}
+	
+	tmpDir := t.TempDir()
+	projectDir := filepath.Join(tmpDir, "web")
+	write := func(name, content string) {
+		t.Helper()
+		full := filepath.Join(projectDir, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("src/index.ts", "export const a = 1")
+	write(".buildignore", "*.log\n")
+	
+	base, _ := cache.Key(task, tmpDir, "npm run build")
+	
+	// Ignored and output files must not change the key
+	write("debug.log", "noise")
+	write("dist/index.js", "compiled")
+	write("node_modules/x/index.js", "dep")
+	write("test-results/junit.xml", "<testsuites/>")
+	if key, _ := cache.Key(task, tmpDir, "npm run build"); key != base {
+		t.Errorf("Ignored files changed the cache key: %s != %s", key, base)
+	}
+	
+	// Editing a source file must change the key
+	write("src/index.ts", "export const a = 2")
+	edited, _ := cache.Key(task, tmpDir, "npm run build")
+	if edited == base {
+		t.Error("Expected source edit to change the cache key")
+	}
+	
+	// So must a different build command
+	if key, _ := cache.Key(task, tmpDir, "npm run build:prod"); key == edited {
+		t.Error("Expected build command to change the cache key")
+	}
+
+	// Output folder names are only skipped at the project root
+	write("src/build/config.ts", "export const b = 1")
+	if key, _ := cache.Key(task, tmpDir, "npm run build"); key == edited {
+		t.Error("Expected a source file under src/build to change the cache key")
+	}
+}
+
 func TestDetectProjectType(t *testing.T) {
 	// Create temporary directory for testing
 	tmpDir := t.TempDir()
@@ -133,6 +384,305 @@ func TestDetectProjectType(t *testing.T) {
 	}
 }
 
+func TestDetectScan(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"api/api.csproj":                   "<Project></Project>",
+		"web/package.json":                 `{"packageManager": "pnpm@8.15.0", "scripts": {"build:prod": "vite build"}}`,
+		"web/vite.config.ts":               "export default {}",
+		"web/node_modules/dep/package.json": `{"name": "dep"}`,
+	}
+	for name, content := range files {
+		full := filepath.Join(tmpDir, name)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	projects, err := detect.Scan(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to scan: %v", err)
+	}
+	if len(projects) != 2 {
+		t.Fatalf("Expected 2 projects (node_modules skipped), got %+v", projects)
+	}
+	if projects[0].Path != "api" || projects[0].Kind != "dotnet" {
+		t.Errorf("Unexpected first project: %+v", projects[0])
+	}
+	web := projects[1]
+	if web.PackageManager != "pnpm" || web.Framework != "vite" || web.BuildScripts[0] != "build:prod" {
+		t.Errorf("Unexpected node project: %+v", web.ProjectType)
+	}
+}
+
+func TestArtifactCollect(t *testing.T) {
+	tmpDir := t.TempDir()
+	projectDir := filepath.Join(tmpDir, "web")
+	if err := os.MkdirAll(filepath.Join(projectDir, "dist", "assets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(projectDir, "dist", "assets", "app.js"), []byte("console.log(1)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	
+	outDir := filepath.Join(tmpDir, "out")
+	collected, err := artifact.Collect(projectDir, outDir, []string{"build", "dist"})
+	if err != nil {
+		t.Fatalf("Failed to collect artifacts: %v", err)
+	}
+	
+	if len(collected) != 1 || collected[0] != "dist" {
+		t.Errorf("Expected only dist to be collected, got %+v", collected)
+	}
+	
+	if _, err := os.Stat(filepath.Join(outDir, "dist", "assets", "app.js")); err != nil {
+		t.Errorf("Expected collected file in out dir: %v", err)
+	}
+	
+	if _, err := artifact.Collect(projectDir, outDir, []string{"../escape"}); err == nil {
+		t.Error("Expected path traversal to be rejected")
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	plan := planner.Plan{Tasks: []planner.Task{
+		{Path: "a", Kind: "node", Version: "20"},
+		{Path: "b", Kind: "node", Version: "20"},
+		{Path: "c", Kind: "node", Version: "20"},
+	}}
+	states := map[string]*taskState{
+		plan.Tasks[0].ID(): {status: statusSucceeded},
+		plan.Tasks[1].ID(): {status: statusFailed, err: os.ErrNotExist},
+		plan.Tasks[2].ID(): {status: statusSkipped},
+	}
+	
+	if failed := summarize(plan, states, logging.New(true)); failed != 1 {
+		t.Errorf("Expected 1 failed task, got %d", failed)
+	}
+}
+
+func TestGoToolchain(t *testing.T) {
+	tmpDir := t.TempDir()
+	goMod := "module example.com/svc\n\ngo 1.22\n\ntoolchain go1.22.5\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "go.mod"), []byte(goMod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	
+	pt := detect.InferProjectType(tmpDir)
+	if pt == nil || pt.Kind != "go" {
+		t.Fatalf("Expected go project detection, got %+v", pt)
+	}
+	if v := toolchain.GoVersion(tmpDir); v != "1.22.5" {
+		t.Errorf("Expected toolchain directive version 1.22.5, got %s", v)
+	}
+	
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{Go: config.VersionSet{Versions: []string{"1.21", "1.22"}}},
+		Matrix:  []config.MatrixEntry{{Path: ".", Type: "go", GoVersions: []string{"1.22"}}},
+	}
+	plan, err := planner.ExpandIn(tmpDir, cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
+	if len(plan.Tasks) != 1 || plan.Tasks[0].Version != "1.22" {
+		t.Fatalf("Expected goVersions to override the runtime set, got %+v", plan.Tasks)
+	}
+	image, command := runner.DockerSpec(plan.Tasks[0], "", nil)
+	if image != "golang:1.22" || !strings.Contains(command, "GOFLAGS=-trimpath") {
+		t.Errorf("Unexpected docker spec: %s %s", image, command)
+	}
+}
+
+func TestPythonToolchain(t *testing.T) {
+	tmpDir := t.TempDir()
+	for name, content := range map[string]string{
+		"pyproject.toml": "[tool.poetry]\nname = \"lib\"\n",
+		"poetry.lock":    "# lock",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	pt := detect.InferProjectType(tmpDir)
+	if pt == nil || pt.Kind != "python" || pt.PackageManager != "poetry" {
+		t.Fatalf("Expected poetry project detection, got %+v", pt)
+	}
+	
+	task := planner.Task{Path: ".", Kind: "python", Version: "3.12"}
+	image, command := runner.DockerSpec(task, pt.PackageManager, nil)
+	if image != "python:3.12" || !strings.Contains(command, "poetry build") {
+		t.Errorf("Unexpected docker spec: %s %s", image, command)
+	}
+	
+	// The poetry lock file is part of the cache key
+	before, _ := cache.Key(task, tmpDir)
+	if err := os.WriteFile(filepath.Join(tmpDir, "poetry.lock"), []byte("# relocked"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := cache.Key(task, tmpDir); after == before {
+		t.Error("Expected poetry.lock change to change the cache key")
+	}
+
+	// Pipfile.lock projects build in the locked pipenv environment
+	if err := os.Remove(filepath.Join(tmpDir, "poetry.lock")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "Pipfile.lock"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if pt = detect.InferProjectType(tmpDir); pt == nil || pt.PackageManager != "pipenv" {
+		t.Fatalf("Expected pipenv project detection, got %+v", pt)
+	}
+	if _, command = runner.DockerSpec(task, pt.PackageManager, nil); !strings.Contains(command, "pipenv install --deploy") || !strings.Contains(command, "pipenv run python -m build") {
+		t.Errorf("Unexpected pipenv command: %s", command)
+	}
+}
+
+// writeFiles creates the files under root, with their parent directories.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJavaToolchain(t *testing.T) {
+	tmpDir := t.TempDir()
+	svcDir := filepath.Join(tmpDir, "svc")
+	if err := os.MkdirAll(filepath.Join(svcDir, "target", "classes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"pom.xml":             "<project/>",
+		"target/svc-1.0.jar":  "jar",
+		"target/classes/A.class": "class",
+	} {
+		if err := os.WriteFile(filepath.Join(svcDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	
+	// A typed entry still gets its build tool detected
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{Java: config.VersionSet{Versions: []string{"21"}}},
+		Matrix:  []config.MatrixEntry{{Path: "svc", Type: "java"}},
+	}
+	plan, err := planner.ExpandIn(tmpDir, cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
+	task := plan.Tasks[0]
+	if task.Entry.PackageManager != "maven" {
+		t.Fatalf("Expected maven to be detected, got %+v", task.Entry)
+	}
+	image, command := runner.DockerSpec(task, task.Entry.PackageManager, nil)
+	if image != "maven:3-eclipse-temurin-21" || !strings.Contains(command, "mvn -B -ntp package") {
+		t.Errorf("Unexpected docker spec: %s %s", image, command)
+	}
+
+	// Other typed entries keep their default package manager
+	writeFiles(t, tmpDir, map[string]string{
+		"web/package.json":   `{"name":"web"}`,
+		"web/pnpm-lock.yaml": "lockfileVersion: 9",
+	})
+	nodeCfg := &config.Root{
+		Runtime: config.RuntimeConfig{Node: config.VersionSet{Versions: []string{"20"}}},
+		Matrix:  []config.MatrixEntry{{Path: "web", Type: "node"}},
+	}
+	if nodePlan, err := planner.ExpandIn(tmpDir, nodeCfg, nil); err != nil || nodePlan.Tasks[0].Entry.PackageManager != "" {
+		t.Errorf("Expected a typed node entry to keep npm, got %+v, %v", nodePlan, err)
+	}
+	
+	// Only the jar is collected, not the compiled classes
+	outDir := filepath.Join(tmpDir, "out")
+	collected, err := runner.CollectArtifacts(task, runner.Options{WorkspaceRoot: tmpDir, OutDir: outDir})
+	if err != nil {
+		t.Fatalf("Failed to collect artifacts: %v", err)
+	}
+	if len(collected) != 1 || collected[0] != "target/svc-1.0.jar" {
+		t.Errorf("Expected only the jar to be collected, got %+v", collected)
+	}
+}
+
+func TestRustToolchain(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "Cargo.toml"), []byte("[package]\nname = \"cli\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{Rust: config.VersionSet{Versions: []string{"1.78"}}},
+		Matrix:  []config.MatrixEntry{{Path: ".", RustVersions: []string{"1.79", "1.80"}}},
+	}
+	plan, err := planner.ExpandIn(tmpDir, cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
+	if len(plan.Tasks) != 2 || plan.Tasks[0].Kind != "rust" || plan.Tasks[0].Version != "1.79" {
+		t.Fatalf("Expected two rust tasks from rustVersions, got %+v", plan.Tasks)
+	}
+	
+	image, command := runner.DockerSpec(plan.Tasks[0], "", nil)
+	if image != "rust:1.79" || !strings.Contains(command, "cargo build --release --locked") {
+		t.Errorf("Unexpected docker spec: %s %s", image, command)
+	}
+	
+	tc, _ := toolchain.Lookup("rust")
+	if _, ok := tc.(toolchain.VolumeProvider); !ok {
+		t.Error("Expected rust toolchain to keep a cargo registry volume")
+	}
+}
+
+func TestNativeResolve(t *testing.T) {
+	installed := []string{"18.20.2", "20.9.0", "20.11.1", "8.0.100-preview.1", "8.0.100"}
+	for requested, want := range map[string]string{
+		"20":       "20.11.1",
+		"v20.9.0":  "20.9.0",
+		"8.0":      "8.0.100",
+		"22":       "",
+	} {
+		if got := toolchain.MatchVersion(requested, installed); got != want {
+			t.Errorf("MatchVersion(%s) = %q, want %q", requested, got, want)
+		}
+	}
+	
+	// Node versions installed by nvm are found without touching PATH
+	nvmDir := t.TempDir()
+	binDir := filepath.Join(nvmDir, "versions", "node", "v20.11.1", "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(binDir, "node"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("NVM_DIR", nvmDir)
+	
+	tc, _ := toolchain.Lookup("node")
+	resolver := tc.(toolchain.NativeResolver)
+	env, err := resolver.ResolveNative(config.MatrixEntry{}, "20")
+	if err != nil {
+		t.Fatalf("Failed to resolve node 20: %v", err)
+	}
+	if len(env.Path) != 1 || env.Path[0] != binDir {
+		t.Errorf("Expected nvm bin dir, got %+v", env.Path)
+	}
+	
+	t.Setenv("PATH", "")
+	if _, err := resolver.ResolveNative(config.MatrixEntry{}, "99"); err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("Expected clear not-installed error, got %v", err)
+	}
+}
+
 func TestParseOnly(t *testing.T) {
 	// Test empty selection
 	result := parseOnlyHelper("")
@@ -172,4 +722,994 @@ func parseOnlyHelper(only string) map[string]struct{} {
 		}
 	}
 	return m
-}
\ No newline at end of file
+}
+
+func TestEngine(t *testing.T) {
+	if _, err := engine.New("lxc"); err == nil {
+		t.Error("Expected an error for an unknown engine")
+	}
+	def, err := engine.New("")
+	if err != nil || def.Name != engine.Docker {
+		t.Fatalf("Expected docker by default, got %v (%v)", def, err)
+	}
+	if got := def.Mount("/src", "/workspace", true); got != "/src:/workspace" {
+		t.Errorf("docker mount = %q", got)
+	}
+	if got := def.Ref("myorg/app:1.0"); got != "myorg/app:1.0" {
+		t.Errorf("docker ref = %q", got)
+	}
+
+	podman := &engine.Engine{Name: engine.Podman, Binary: "podman", Rootless: true}
+	if got := podman.Mount("/src", "/workspace", true); got != "/src:/workspace:z" {
+		t.Errorf("podman shared mount = %q", got)
+	}
+	if got := podman.Mount("/src", "/cache", false); got != "/src:/cache:Z" {
+		t.Errorf("podman private mount = %q", got)
+	}
+	if got := strings.Join(podman.RunArgs(), " "); got != "--userns=keep-id" {
+		t.Errorf("rootless podman run args = %q", got)
+	}
+	for ref, want := range map[string]string{
+		"app:1.0":              "docker.io/library/app:1.0",
+		"myorg/app:1.0":        "docker.io/myorg/app:1.0",
+		"ghcr.io/myorg/app":    "ghcr.io/myorg/app",
+		"localhost:5000/app":   "localhost:5000/app",
+	} {
+		if got := podman.Ref(ref); got != want {
+			t.Errorf("podman Ref(%s) = %q, want %q", ref, got, want)
+		}
+	}
+
+	if err := validateYAML(t, "runtime:\n  node:\n    versions: [\"20\"]\nmatrix: []\ndefaults:\n  engine: lxc\n"); err == nil || !strings.Contains(err.Error(), `unknown container engine "lxc"`) {
+		t.Errorf("Expected an unknown engine error, got %v", err)
+	}
+}
+
+func TestDockerClient(t *testing.T) {
+	var contextFiles []string
+	created := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "OK") })
+	mux.HandleFunc("/build", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query()["t"]; strings.Join(got, ",") != "app:1,app:latest" {
+			t.Errorf("build tags = %v", got)
+		}
+		tr := tar.NewReader(r.Body)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			contextFiles = append(contextFiles, hdr.Name)
+		}
+		io.WriteString(w, `{"stream":"Step 1/1 : FROM scratch\n"}`+"\n")
+		io.WriteString(w, `{"aux":{"ID":"sha256:abc"}}`+"\n")
+	})
+	mux.HandleFunc("/images/app/push", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Registry-Auth") == "" {
+			t.Error("push without X-Registry-Auth header")
+		}
+		if r.URL.Query().Get("tag") == "broken" {
+			io.WriteString(w, `{"errorDetail":{"message":"denied: requested access to the resource is denied"},"error":"denied"}`+"\n")
+			return
+		}
+		io.WriteString(w, `{"status":"Pushed","id":"abc"}`+"\n")
+		io.WriteString(w, `{"aux":{"Tag":"1","Digest":"sha256:def","Size":1}}`+"\n")
+	})
+	mux.HandleFunc("/images/missing/tag", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message":"No such image: missing"}`)
+	})
+	nodePulled := false
+	mux.HandleFunc("/images/create", func(w http.ResponseWriter, r *http.Request) {
+		nodePulled = nodePulled || r.URL.Query().Get("fromImage") == "node"
+		io.WriteString(w, `{"status":"Pulling from library/alpine"}`+"\n")
+	})
+	mux.HandleFunc("/images/node:20/json", func(w http.ResponseWriter, r *http.Request) {
+		if !nodePulled {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"No such image: node:20"}`)
+			return
+		}
+		io.WriteString(w, `{"RepoDigests":["node@sha256:feed"]}`)
+	})
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		if !created {
+			// First attempt: image not present locally
+			created = true
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"No such image: alpine:3"}`)
+			return
+		}
+		var body struct{ HostConfig map[string]interface{} }
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.HostConfig["NetworkMode"] != "none" || body.HostConfig["Memory"] != float64(1<<30) || body.HostConfig["PidsLimit"] != nil {
+			t.Errorf("create HostConfig = %v", body.HostConfig)
+		}
+		io.WriteString(w, `{"Id":"c1"}`)
+	})
+	mux.HandleFunc("/containers/c1/start", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	mux.HandleFunc("/containers/c1/logs", func(w http.ResponseWriter, r *http.Request) {
+		for _, frame := range []struct {
+			stream byte
+			data   string
+		}{{1, "out\n"}, {2, "err\n"}} {
+			hdr := make([]byte, 8)
+			hdr[0] = frame.stream
+			binary.BigEndian.PutUint32(hdr[4:], uint32(len(frame.data)))
+			w.Write(append(hdr, frame.data...))
+		}
+	})
+	mux.HandleFunc("/containers/c1/wait", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, `{"StatusCode":3}`) })
+	mux.HandleFunc("/containers/c1", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	var removedVolumes []string
+	mux.HandleFunc("/volumes", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"Volumes":[{"Name":"slick-autobuild-deps-node-20-npm"},{"Name":"my-slick-autobuild-deps-x"}]}`)
+	})
+	mux.HandleFunc("/volumes/", func(w http.ResponseWriter, r *http.Request) {
+		removedVolumes = append(removedVolumes, strings.TrimPrefix(r.URL.Path, "/volumes/"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	t.Setenv("DOCKER_HOST", "unix://"+socket)
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	client, err := docker.NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	logger := logging.New(true)
+	if err := client.Ping(ctx); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Dockerfile":      "FROM scratch\n",
+		".dockerignore":   "secrets\n*.log\n",
+		"app.txt":         "app",
+		"debug.log":       "log",
+		"secrets/key.pem": "key",
+	})
+	id, err := client.Build(ctx, docker.BuildOptions{ContextDir: dir, Tags: []string{"app:1", "app:latest"}}, logger)
+	if err != nil || id != "sha256:abc" {
+		t.Fatalf("Build = %q, %v", id, err)
+	}
+	got := strings.Join(contextFiles, ",")
+	if got != ".dockerignore,Dockerfile,app.txt" {
+		t.Errorf("build context = %s", got)
+	}
+
+	digest, err := client.Push(ctx, "app:1", logger)
+	if err != nil || digest != "sha256:def" {
+		t.Errorf("Push = %q, %v", digest, err)
+	}
+	var streamErr *docker.StreamError
+	if _, err := client.Push(ctx, "app:broken", logger); !errors.As(err, &streamErr) || !strings.Contains(streamErr.Message, "denied") {
+		t.Errorf("Expected a push StreamError, got %v", err)
+	}
+	if err := client.Tag(ctx, "missing", "app:2"); !docker.IsNotFound(err) {
+		t.Errorf("Expected a not found error, got %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code, err := client.Run(ctx, docker.ContainerSpec{Image: "alpine:3", Cmd: []string{"true"}, NetworkMode: "none", Memory: 1 << 30}, &stdout, &stderr, logger)
+	if err != nil || code != 3 {
+		t.Fatalf("Run = %d, %v", code, err)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("Run output = %q / %q", stdout.String(), stderr.String())
+	}
+
+	if digest, err := docker.ImageDigest(ctx, nil, client, "node:20", logger); err != nil || digest != "sha256:feed" || !nodePulled {
+		t.Errorf("ImageDigest = %q, %v (pulled %t)", digest, err, nodePulled)
+	}
+
+	pruned, err := docker.PruneVolumes(ctx, nil, client, runner.DepCachePrefix)
+	if err != nil || strings.Join(pruned, ",") != "slick-autobuild-deps-node-20-npm" || strings.Join(removedVolumes, ",") != strings.Join(pruned, ",") {
+		t.Errorf("PruneVolumes = %v (removed %v), %v", pruned, removedVolumes, err)
+	}
+
+	bad, _ := docker.NewClient("unix://" + filepath.Join(t.TempDir(), "none.sock"))
+	if err := bad.Ping(ctx); !errors.Is(err, docker.ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+
+	// Hosts and contexts the API client cannot reach fall back to the CLI
+	t.Setenv("DOCKER_HOST", "ssh://builder@ci-host")
+	if _, err := docker.NewClient(""); !errors.Is(err, docker.ErrCLIOnly) {
+		t.Errorf("Expected ErrCLIOnly for ssh://, got %v", err)
+	}
+	if c, err := docker.EngineClient(nil); c != nil || err != nil {
+		t.Errorf("Expected the CLI for ssh://, got %v, %v", c, err)
+	}
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "remote")
+	if c, err := docker.EngineClient(nil); c != nil || err != nil {
+		t.Errorf("Expected the CLI for a docker context, got %v, %v", c, err)
+	}
+}
+
+// shellToolchain builds with a plain shell command on the host.
+type shellToolchain struct{ makeToolchain }
+
+func (shellToolchain) Kind() string                                  { return "shell" }
+func (shellToolchain) Detect(string) *toolchain.ProjectType          { return nil }
+func (shellToolchain) Command(config.MatrixEntry, string) string     { return "echo building" }
+func (shellToolchain) ResolveNative(config.MatrixEntry, string) (*toolchain.NativeEnv, error) {
+	return &toolchain.NativeEnv{}, nil
+}
+
+func TestBuildSteps(t *testing.T) {
+	entry := config.MatrixEntry{Path: "web", PreBuild: []string{"npm run codegen"}, PostBuild: []string{"npm run lint"}}
+	task := planner.Task{Path: "web", Kind: "node", Version: "20", Entry: entry}
+	var names []string
+	for _, s := range runner.BuildSteps(task, "npm", []string{"build:lib", "build:app"}) {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, ","); got != "preBuild 1,install,run build:lib,run build:app,postBuild 1" {
+		t.Errorf("Unexpected steps: %s", got)
+	}
+	_, command := runner.DockerSpec(task, "npm", []string{"build:lib", "build:app"})
+	if command != "npm run codegen && npm install && npm run build:lib && npm run build:app && npm run lint" {
+		t.Errorf("Unexpected command: %s", command)
+	}
+
+	// Hooks must be opted in to
+	if err := validateYAML(t, "runtime:\n  node:\n    versions: [\"20\"]\nmatrix:\n  - path: web\n    type: node\n    preBuild: [\"npm run codegen\"]\n"); err == nil || !strings.Contains(err.Error(), "allowScripts") {
+		t.Errorf("Expected hooks without allowScripts to be rejected, got %v", err)
+	}
+
+	// Each step is timed from the markers in the build output
+	toolchain.Register(shellToolchain{})
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "svc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	task = planner.Task{Path: "svc", Kind: "shell", Version: "1", Entry: config.MatrixEntry{
+		PreBuild:  []string{"echo pre > pre.txt"},
+		PostBuild: []string{"test -f pre.txt", "exit 3"},
+	}}
+	opts := runner.Options{Logger: logging.New(true), WorkspaceRoot: root, Runtime: runner.RuntimeNative}
+	res, err := runner.RunTask(context.Background(), task, opts, "", nil)
+	if err == nil || !strings.Contains(err.Error(), `step "postBuild 2"`) {
+		t.Errorf("Expected the failing step in the error, got %v", err)
+	}
+	names = nil
+	for _, s := range res.Steps {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, ","); got != "preBuild 1,build,postBuild 1,postBuild 2" {
+		t.Errorf("Unexpected step results: %s", got)
+	}
+}
+
+func TestDotnetToolchain(t *testing.T) {
+	selfContained := true
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{Dotnet: config.VersionSet{Versions: []string{"8.0"}}},
+		Matrix: []config.MatrixEntry{{
+			Path: "api",
+			Type: "dotnet",
+			Dotnet: &config.DotnetConfig{
+				Mode:               "publish",
+				RuntimeIdentifiers: []string{"win-x64", "linux-x64"},
+				SelfContained:      &selfContained,
+				Project:            "src/Api/Api.csproj",
+				Properties:         map[string]string{"Version": "1.2.3", "InvariantGlobalization": "true"},
+			},
+		}},
+	}
+	plan, err := planner.ExpandIn(t.TempDir(), cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to expand plan: %v", err)
+	}
+	if len(plan.Tasks) != 2 || plan.Tasks[0].ID() != "api:dotnet@8.0/linux-x64" || plan.Tasks[1].Variant != "win-x64" {
+		t.Fatalf("Expected one task per runtime identifier, got %+v", plan.Tasks)
+	}
+	_, command := runner.DockerSpec(plan.Tasks[0], "", nil)
+	want := "dotnet restore src/Api/Api.csproj -r linux-x64 -p:Configuration=Release -p:SelfContained=true " +
+		"-p:InvariantGlobalization=true -p:Version=1.2.3 && dotnet publish src/Api/Api.csproj -c Release --no-restore -r linux-x64 " +
+		"--self-contained true -p:InvariantGlobalization=true -p:Version=1.2.3 -o publish"
+	if command != want {
+		t.Errorf("Unexpected publish command:\n got %s\nwant %s", command, want)
+	}
+	tc, _ := toolchain.Lookup("dotnet")
+	if dirs := tc.OutputDirs(plan.Tasks[0].Entry); len(dirs) != 1 || dirs[0] != "publish" {
+		t.Errorf("Unexpected publish outputs: %v", dirs)
+	}
+
+	// The default stays a plain Release build
+	if got := tc.Command(config.MatrixEntry{}, "8.0"); got != "dotnet restore -p:Configuration=Release && dotnet build -c Release --no-restore" {
+		t.Errorf("Unexpected default command: %s", got)
+	}
+	pack := config.MatrixEntry{Dotnet: &config.DotnetConfig{Mode: "pack", Configuration: "Debug"}}
+	if got := tc.Command(pack, "8.0"); got != "dotnet restore -p:Configuration=Debug && dotnet pack -c Debug --no-restore -o nupkgs" {
+		t.Errorf("Unexpected pack command: %s", got)
+	}
+
+	err = validateYAML(t, "runtime:\n  dotnet:\n    versions: [\"8.0\"]\nmatrix:\n  - path: api\n    type: dotnet\n    dotnet:\n      mode: deploy\n      project: a.csproj\n      solution: a.sln\n")
+	if err == nil || !strings.Contains(err.Error(), `unknown dotnet mode "deploy"`) || !strings.Contains(err.Error(), "not both") {
+		t.Errorf("Expected dotnet settings problems, got %v", err)
+	}
+}
+
+func TestTestStage(t *testing.T) {
+	toolchain.Register(shellToolchain{})
+	root := t.TempDir()
+	proj := filepath.Join(root, "svc")
+	if err := os.MkdirAll(proj, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// A result file from an earlier run must not be counted
+	old := filepath.Join(proj, "stale.xml")
+	if err := os.WriteFile(old, []byte(`<testsuite><testcase name="old"/></testsuite>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(old, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	junit := `<testsuites><testsuite name="unit">` +
+		`<testcase name="a"/><testcase name="b"><failure message="boom"/></testcase>` +
+		`<testcase name="c"><skipped/></testcase><testcase name="d"/></testsuite></testsuites>`
+	trx := `<TestRun><ResultSummary outcome="Failed"><Counters total="5" executed="4" passed="3" failed="1" error="0" notExecuted="1"/></ResultSummary></TestRun>`
+	task := planner.Task{Path: "svc", Kind: "shell", Version: "1", Entry: config.MatrixEntry{
+		Test: &config.TestConfig{
+			Enabled: true,
+			Command: fmt.Sprintf("echo '%s' > junit.xml && echo '%s' > run.trx && exit 1", junit, trx),
+			Results: []string{"*.xml", "*.trx"},
+		},
+	}}
+	outDir := filepath.Join(root, "out")
+	opts := runner.Options{Logger: logging.New(true), WorkspaceRoot: root, OutDir: outDir, Runtime: runner.RuntimeNative}
+	res, err := runner.RunTask(context.Background(), task, opts, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "tests failed (2 failed, 5 passed, 2 skipped)") {
+		t.Errorf("Expected a test failure summary, got %v", err)
+	}
+	if res.Tests == nil || len(res.Tests.Files) != 2 {
+		t.Fatalf("Expected two collected result files, got %+v", res.Tests)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "test-results", "junit.xml")); err != nil {
+		t.Errorf("Expected result file next to the manifest: %v", err)
+	}
+
+	// Toolchains without a default test command need one configured
+	cfg := &config.Root{
+		Runtime: config.RuntimeConfig{Go: config.VersionSet{Versions: []string{"1.22"}}},
+		Matrix:  []config.MatrixEntry{{Path: "svc", Type: "go", Test: &config.TestConfig{Enabled: true}}},
+	}
+	if _, err := planner.ExpandIn(root, cfg, nil); err == nil || !strings.Contains(err.Error(), "set test.command") {
+		t.Errorf("Expected a missing test command error, got %v", err)
+	}
+	tc, _ := toolchain.Lookup("dotnet")
+	if got := tc.(toolchain.Tester).TestCommand(config.MatrixEntry{}, "8.0"); !strings.Contains(got, "--no-build --logger trx") {
+		t.Errorf("Unexpected dotnet test command: %s", got)
+	}
+	// The tests run against the variant's build output
+	ridEntry := config.MatrixEntry{Dotnet: &config.DotnetConfig{RuntimeIdentifiers: []string{"linux-x64"}}}
+	if got := tc.(toolchain.Tester).TestCommand(ridEntry, "8.0"); !strings.Contains(got, "--no-build -r linux-x64") {
+		t.Errorf("Expected the variant's runtime identifier, got %s", got)
+	}
+}
+
+func TestTimeoutAndRetries(t *testing.T) {
+	toolchain.Register(shellToolchain{})
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "svc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logger := logging.New(true)
+
+	// Fails on the first attempt only, like a flaky registry pull
+	flaky := planner.Task{Path: "svc", Kind: "shell", Version: "1", Entry: config.MatrixEntry{
+		AllowScripts: true,
+		PreBuild:     []string{"test -f attempted || { touch attempted; exit 1; }"},
+	}}
+	opts := runner.Options{Logger: logger, WorkspaceRoot: root, Runtime: runner.RuntimeNative, Retries: 2, RetryBackoff: time.Millisecond}
+	res, err := runner.RunTask(context.Background(), flaky, opts, "", nil)
+	if err != nil {
+		t.Fatalf("Expected the retry to succeed: %v", err)
+	}
+	if len(res.Retries) != 1 || res.Retries[0].Attempt != 1 || !strings.Contains(res.Retries[0].Error, "preBuild 1") {
+		t.Errorf("Expected one recorded retry, got %+v", res.Retries)
+	}
+
+	// A hung step is killed when the timeout expires
+	hung := planner.Task{Path: "svc", Kind: "shell", Version: "1", Entry: config.MatrixEntry{
+		AllowScripts: true,
+		PreBuild:     []string{"sleep 30"},
+	}}
+	opts = runner.Options{Logger: logger, WorkspaceRoot: root, Runtime: runner.RuntimeNative, Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	_, err = runner.RunTask(context.Background(), hung, opts, "", nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Timed out build took %s to stop", elapsed)
+	}
+
+	err = validateYAML(t, "runtime:\n  node:\n    versions: [\"20\"]\nmatrix:\n  - path: web\n    type: node\n    timeout: soon\n    retries: -1\ndefaults:\n  timeout: 30m\n")
+	if err == nil || !strings.Contains(err.Error(), `invalid duration "soon"`) || !strings.Contains(err.Error(), "negative") {
+		t.Errorf("Expected timeout and retries problems, got %v", err)
+	}
+}
+
+func TestEnvAndSecrets(t *testing.T) {
+	toolchain.Register(shellToolchain{})
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "svc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FEED_HOST", "feed.example.com")
+	t.Setenv("CI_NPM_TOKEN", "npm-secret-1234")
+	tokenFile := filepath.Join(root, "nuget-token")
+	if err := os.WriteFile(tokenFile, []byte("nuget-secret-5678\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Root{
+		Defaults: config.DefaultSection{
+			Env:     map[string]string{"NODE_ENV": "production", "FEED": "https://${FEED_HOST}/v3"},
+			Secrets: []config.SecretConfig{{Name: "NPM_TOKEN", Env: "CI_NPM_TOKEN"}},
+		},
+		Matrix: []config.MatrixEntry{{
+			Path: "svc", Type: "shell", Versions: []string{"1"}, AllowScripts: true,
+			Env:       map[string]string{"NODE_ENV": "test"},
+			Secrets:   []config.SecretConfig{{Name: "NUGET_TOKEN_FILE", File: tokenFile}},
+			PostBuild: []string{`printf '%s|%s|%s|%s' "$NODE_ENV" "$FEED" "$NPM_TOKEN" "$(cat "$NUGET_TOKEN_FILE")" > env.txt`},
+		}},
+	}
+	plan, err := planner.ExpandIn(root, cfg, nil)
+	if err != nil || len(plan.Tasks) != 1 {
+		t.Fatalf("Expected one task, got %v (%v)", plan.Tasks, err)
+	}
+	task := plan.Tasks[0]
+	env := runner.BuildEnv(task)
+	if strings.Join(env, ",") != "FEED=https://feed.example.com/v3,NODE_ENV=test" {
+		t.Errorf("Expected merged, expanded env without secrets, got %v", env)
+	}
+
+	logger := logging.New(true)
+	opts := runner.Options{Logger: logger, WorkspaceRoot: root, Runtime: runner.RuntimeNative}
+	if _, err := runner.RunTask(context.Background(), task, opts, "", nil); err != nil {
+		t.Fatalf("RunTask failed: %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(root, "svc", "env.txt"))
+	if string(got) != "test|https://feed.example.com/v3|npm-secret-1234|nuget-secret-5678" {
+		t.Errorf("Unexpected build environment %q", got)
+	}
+	if s := logger.Scrub("token npm-secret-1234 and nuget-secret-5678"); s != "token *** and ***" {
+		t.Errorf("Expected secrets to be masked, got %q", s)
+	}
+
+	// Rotating a secret must not change the cache key
+	key := func() string {
+		k, err := cache.Key(task, root, runner.BuildEnv(task)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	before := key()
+	t.Setenv("CI_NPM_TOKEN", "rotated-token")
+	if key() != before {
+		t.Error("Expected secrets to stay out of the cache key")
+	}
+	t.Setenv("FEED_HOST", "other.example.com")
+	if key() == before {
+		t.Error("Expected env changes to change the cache key")
+	}
+
+	// Engine API builds source the secrets from a mounted file, keeping
+	// them out of the container's inspectable Env
+	t.Setenv("CI_NPM_TOKEN", "it's-secret")
+	var createBody struct {
+		Env        []string
+		Cmd        []string
+		HostConfig struct{ Binds []string }
+	}
+	sourced := ""
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "OK") })
+	mux.HandleFunc("/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&createBody)
+		for _, b := range createBody.HostConfig.Binds {
+			if host, rest, _ := strings.Cut(b, ":"); strings.HasPrefix(rest, "/run/slick-autobuild/") {
+				out, _ := exec.Command("bash", "-c", `. "$1" && printf %s "$NPM_TOKEN"`, "bash", host).Output()
+				sourced = string(out)
+			}
+		}
+		io.WriteString(w, `{"Id":"c1"}`)
+	})
+	mux.HandleFunc("/containers/c1/start", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	mux.HandleFunc("/containers/c1/logs", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/containers/c1/wait", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, `{"StatusCode":0}`) })
+	mux.HandleFunc("/containers/c1", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	defer srv.Close()
+	client, err := docker.NewClient("unix://" + socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiOpts := runner.Options{Logger: logger, WorkspaceRoot: root, Client: client}
+	if _, err := runner.RunTask(context.Background(), task, apiOpts, "", nil); err != nil {
+		t.Fatalf("RunTask over the Engine API failed: %v", err)
+	}
+	if strings.Contains(strings.Join(createBody.Env, ","), "NPM_TOKEN") {
+		t.Errorf("Expected secrets to stay out of the container Env, got %v", createBody.Env)
+	}
+	if sourced != "it's-secret" || len(createBody.Cmd) != 3 || !strings.HasPrefix(createBody.Cmd[2], ". /run/slick-autobuild/secrets.sh") {
+		t.Errorf("Expected the script to source the mounted secrets, got %q from %v", sourced, createBody.Cmd)
+	}
+
+	os.Unsetenv("CI_NPM_TOKEN")
+	if _, err := runner.RunTask(context.Background(), task, opts, "", nil); err == nil || !strings.Contains(err.Error(), "CI_NPM_TOKEN is not set") {
+		t.Errorf("Expected a missing secret error, got %v", err)
+	}
+
+	err = validateYAML(t, "runtime:\n  node:\n    versions: [\"20\"]\nmatrix:\n  - path: web\n    type: node\n    env:\n      BAD-NAME: x\n    secrets:\n      - name: TOKEN\n        env: TOKEN\n        file: token.txt\n      - env: OTHER\n")
+	if err == nil || !strings.Contains(err.Error(), `invalid environment variable name "BAD-NAME"`) ||
+		!strings.Contains(err.Error(), "not both") || !strings.Contains(err.Error(), "secret name is required") {
+		t.Errorf("Expected env and secret problems, got %v", err)
+	}
+}
+
+func TestDepCache(t *testing.T) {
+	task := func(dc *config.DepCacheConfig) planner.Task {
+		return planner.Task{Path: "apps/web", Kind: "node", Version: "20", Entry: config.MatrixEntry{PackageManager: "npm", DepCache: dc}}
+	}
+	binds, err := runner.CacheBinds(task(nil), nil)
+	if err != nil || strings.Join(binds, ",") != "slick-autobuild-deps-node-20-npm:/tmp/slick-autobuild-cache/npm" {
+		t.Errorf("shared binds = %v, %v", binds, err)
+	}
+	binds, _ = runner.CacheBinds(task(&config.DepCacheConfig{Mode: "isolated"}), nil)
+	if strings.Join(binds, ",") != "slick-autobuild-deps-node-20-npm-apps-web:/tmp/slick-autobuild-cache/npm" {
+		t.Errorf("isolated binds = %v", binds)
+	}
+	if binds, _ = runner.CacheBinds(task(&config.DepCacheConfig{Mode: "off"}), nil); len(binds) != 0 {
+		t.Errorf("Expected no binds when off, got %v", binds)
+	}
+
+	dir := t.TempDir()
+	podman := &engine.Engine{Name: engine.Podman, Binary: "podman"}
+	binds, err = runner.CacheBinds(task(&config.DepCacheConfig{Dir: dir}), podman)
+	want := filepath.Join(dir, "slick-autobuild-deps-node-20-npm") + ":/tmp/slick-autobuild-cache/npm:z"
+	if err != nil || strings.Join(binds, ",") != want {
+		t.Errorf("host dir binds = %v, %v; want %s", binds, err, want)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := runner.PruneDepDirs(dir)
+	if err != nil || len(removed) != 1 {
+		t.Errorf("PruneDepDirs = %v, %v", removed, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+		t.Error("PruneDepDirs removed an unrelated file")
+	}
+
+	// Entries inherit defaults.depCache, overriding single fields
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "api"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Root{
+		Defaults: config.DefaultSection{DepCache: config.DepCacheConfig{Mode: "isolated", Dir: ".deps"}},
+		Matrix:   []config.MatrixEntry{{Path: "api", Type: "dotnet", Frameworks: []string{"8.0"}, DepCache: &config.DepCacheConfig{Mode: "shared"}}},
+	}
+	plan, err := planner.ExpandIn(root, cfg, nil)
+	if err != nil || len(plan.Tasks) != 1 {
+		t.Fatalf("Expected one task, got %v (%v)", plan.Tasks, err)
+	}
+	if dc := plan.Tasks[0].Entry.DepCache; dc == nil || dc.Mode != "shared" || dc.Dir != ".deps" {
+		t.Errorf("Expected merged depCache, got %+v", dc)
+	}
+
+	if err := validateYAML(t, "runtime:\n  node:\n    versions: [\"20\"]\nmatrix: []\ndefaults:\n  depCache:\n    mode: global\n"); err == nil || !strings.Contains(err.Error(), `unknown depCache mode "global"`) {
+		t.Errorf("Expected a depCache mode error, got %v", err)
+	}
+}
+
+// fakeEngine returns an engine whose CLI only records its invocations, and
+// a func that returns the containers run since it was last called
+func fakeEngine(t *testing.T) (*engine.Engine, func() []string) {
+	t.Helper()
+	bin := t.TempDir()
+	log := filepath.Join(bin, "calls.log")
+	script := filepath.Join(bin, "docker")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$*\" >> "+log+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &engine.Engine{Name: engine.Docker, Binary: script}, func() []string {
+		data, _ := os.ReadFile(log)
+		os.Remove(log)
+		// The build script spans several lines; each call starts with "run"
+		return strings.Split(string(data), "run --rm")[1:]
+	}
+}
+
+func TestContainerUser(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	eng, runs := fakeEngine(t)
+
+	task := planner.Task{Path: "web", Kind: "node", Version: "20", Entry: config.MatrixEntry{PackageManager: "npm"}}
+	opts := runner.Options{Logger: logging.New(true), WorkspaceRoot: root, Engine: eng, User: "1000:1000"}
+	for i := 0; i < 2; i++ {
+		if _, err := runner.RunTask(context.Background(), task, opts, "npm", nil); err != nil {
+			t.Fatalf("RunTask failed: %v", err)
+		}
+	}
+	calls := runs()
+	if len(calls) != 3 {
+		t.Fatalf("Expected one volume setup and two builds, got %q", calls)
+	}
+	setup := calls[0]
+	if !strings.Contains(setup, "--user 0:0 -v slick-autobuild-deps-node-20-npm:/tmp/slick-autobuild-cache/npm node:20 sh -c find") ||
+		!strings.Contains(setup, "chown -h 1000:1000") {
+		t.Errorf("Unexpected volume setup: %s", setup)
+	}
+	build := calls[1]
+	for _, want := range []string{"--user 1000:1000", "-e HOME=" + runner.BuildHome, "-e npm_config_cache=/tmp/slick-autobuild-cache/npm", `mkdir -p "$HOME/.local/bin"`} {
+		if !strings.Contains(build, want) {
+			t.Errorf("Expected %q in build call: %s", want, build)
+		}
+	}
+
+	// corepack shims and pip's user installs land in ~/.local/bin, which is
+	// on PATH ahead of the image's root-owned directories
+	for _, tc := range []struct {
+		task       planner.Task
+		pkgManager string
+		want       string
+	}{
+		{planner.Task{Path: "web", Kind: "node", Version: "20", Entry: config.MatrixEntry{PackageManager: "pnpm"}}, "pnpm",
+			`corepack enable --install-directory "$HOME/.local/bin"; }`},
+		{planner.Task{Path: "web", Kind: "python", Version: "3.12", Entry: config.MatrixEntry{PackageManager: "poetry"}}, "poetry",
+			"( pip install --quiet poetry\n) || exit $?\necho '::slick-autobuild-step::1'\n( poetry build\n)"},
+	} {
+		if _, err := runner.RunTask(context.Background(), tc.task, opts, tc.pkgManager, nil); err != nil {
+			t.Fatalf("RunTask failed: %v", err)
+		}
+		calls := runs()
+		_, script, _ := strings.Cut(calls[len(calls)-1], " bash -lc ")
+		setup := "mkdir -p \"$HOME/.local/bin\" || exit $?\nexport PATH=\"$HOME/.local/bin:$PATH\"\n"
+		if !strings.HasPrefix(script, setup) || !strings.Contains(script, tc.want) {
+			t.Errorf("Unexpected %s build script: %q", tc.pkgManager, script)
+		}
+	}
+
+	// Images that need root opt out
+	task.Entry.RunAsRoot = true
+	if _, err := runner.RunTask(context.Background(), task, opts, "npm", nil); err != nil {
+		t.Fatalf("RunTask failed: %v", err)
+	}
+	calls = runs()
+	if got := strings.Join(calls, ""); strings.Contains(got, "--user") || strings.Contains(got, "HOME=") {
+		t.Errorf("Expected the image's user with runAsRoot, got %s", got)
+	}
+}
+
+func TestScratch(t *testing.T) {
+	toolchain.Register(shellToolchain{})
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"apps/web/src/index.js":        "console.log(1)",
+		"apps/web/.gitignore":          "*.log\n",
+		"apps/web/debug.log":           "noise",
+		"apps/web/node_modules/x/a.js": "dep",
+		"apps/web/target/stale.txt":    "old output",
+		"apps/web/src/target/keep.txt": "source",
+		"shared/lib.txt":               "shared",
+	})
+
+	task := planner.Task{Path: "apps/web", Kind: "shell", Version: "1", Entry: config.MatrixEntry{
+		AllowScripts: true,
+		PostBuild:    []string{"mkdir -p dist && cat ../../shared/lib.txt src/index.js > dist/out.txt && ls node_modules debug.log > dist/leaked.txt 2>&1; true"},
+	}}
+	scratch, err := runner.NewScratch(root, task)
+	if err != nil {
+		t.Fatalf("NewScratch failed: %v", err)
+	}
+	if !strings.HasPrefix(scratch.Dir, filepath.Join(root, ".buildcache", "scratch")) {
+		t.Errorf("Expected the copy under .buildcache/scratch, got %s", scratch.Dir)
+	}
+
+	outDir := t.TempDir()
+	opts := runner.Options{Logger: logging.New(true), WorkspaceRoot: root, OutDir: outDir, ArtifactDir: "dist", Runtime: runner.RuntimeNative, Scratch: scratch}
+	if _, err := runner.RunTask(context.Background(), task, opts, "", nil); err != nil {
+		t.Fatalf("RunTask failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "apps/web/dist")); err == nil {
+		t.Error("Expected the build not to write to the workspace")
+	}
+	leaked, _ := os.ReadFile(filepath.Join(scratch.Dir, "dist/leaked.txt"))
+	if strings.Contains(string(leaked), "a.js") || !strings.Contains(string(leaked), "No such file") {
+		t.Errorf("Expected node_modules to stay out of the copy, got %q", leaked)
+	}
+	// Everything else is copied, except the toolchain's outputs at the
+	// project root
+	for name, want := range map[string]bool{"debug.log": true, "src/target/keep.txt": true, "target/stale.txt": false} {
+		if _, err := os.Stat(filepath.Join(scratch.Dir, name)); (err == nil) != want {
+			t.Errorf("Expected %s copied: %t", name, want)
+		}
+	}
+
+	artifacts, err := runner.CollectArtifacts(task, opts)
+	if err != nil || strings.Join(artifacts, ",") != "dist" {
+		t.Fatalf("CollectArtifacts = %v, %v", artifacts, err)
+	}
+	if err := scratch.CopyBack(artifacts); err != nil {
+		t.Fatalf("CopyBack failed: %v", err)
+	}
+	got, _ := os.ReadFile(filepath.Join(root, "apps/web/dist/out.txt"))
+	if string(got) != "sharedconsole.log(1)" {
+		t.Errorf("Expected the output copied back to the workspace, got %q", got)
+	}
+	if err := scratch.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "shared/lib.txt")); err != nil {
+		t.Error("Removing the copy touched the workspace")
+	}
+
+	// Containers see the workspace read-only with the copy over the project
+	eng, runs := fakeEngine(t)
+	scratch, _ = runner.NewScratch(root, task)
+	defer scratch.Remove()
+	opts = runner.Options{Logger: logging.New(true), WorkspaceRoot: root, Engine: eng, Scratch: scratch}
+	task.Kind = "node"
+	if _, err := runner.RunTask(context.Background(), task, opts, "npm", nil); err != nil {
+		t.Fatalf("RunTask failed: %v", err)
+	}
+	calls := strings.Join(runs(), "")
+	want := "-v " + root + ":/workspace:ro -v " + scratch.Dir + ":/workspace/apps/web "
+	if !strings.Contains(calls, want) {
+		t.Errorf("Expected %q in %s", want, calls)
+	}
+
+	// Referenced .NET projects build into their own bin and obj, so they
+	// are copied too and mounted writable over the read-only workspace
+	writeFiles(t, root, map[string]string{
+		"src/Api/Api.csproj": `<Project Sdk="Microsoft.NET.Sdk"><ItemGroup><ProjectReference Include="..\Lib\Lib.csproj" /></ItemGroup></Project>`,
+		"src/Lib/Lib.csproj": `<Project Sdk="Microsoft.NET.Sdk"><ItemGroup><ProjectReference Include="../../core/Core.csproj" /></ItemGroup></Project>`,
+		"src/Lib/Class1.cs":  "class C {}",
+		"core/Core.csproj":   `<Project Sdk="Microsoft.NET.Sdk" />`,
+		"src/Other/Other.cs": "class O {}",
+	})
+	api := planner.Task{Path: "src/Api", Kind: "dotnet", Version: "8.0"}
+	scratch, err = runner.NewScratch(root, api)
+	if err != nil {
+		t.Fatalf("NewScratch failed: %v", err)
+	}
+	defer scratch.Remove()
+	if got := strings.Join(scratch.References, ","); got != "src/Lib,core" {
+		t.Errorf("Expected the referenced projects, got %s", got)
+	}
+	for _, dir := range []string{"src", "src/Lib", "core"} {
+		if info, err := os.Lstat(filepath.Join(scratch.Root, dir)); err != nil || !info.IsDir() {
+			t.Errorf("Expected %s to be a real directory in the copy", dir)
+		}
+	}
+	if info, err := os.Lstat(filepath.Join(scratch.Root, "src/Other")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Error("Expected unreferenced projects to stay symlinked")
+	}
+	if err := os.MkdirAll(filepath.Join(scratch.Root, "src/Lib/obj"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "src/Lib/obj")); err == nil {
+		t.Error("Expected the referenced project's build not to write to the workspace")
+	}
+	if _, err := runner.RunTask(context.Background(), api, runner.Options{Logger: logging.New(true), WorkspaceRoot: root, Engine: eng, Scratch: scratch}, "", nil); err != nil {
+		t.Fatalf("RunTask failed: %v", err)
+	}
+	calls = strings.Join(runs(), "")
+	want = "-v " + filepath.Join(scratch.Root, "src/Lib") + ":/workspace/src/Lib -v " + filepath.Join(scratch.Root, "core") + ":/workspace/core "
+	if !strings.Contains(calls, want) {
+		t.Errorf("Expected %q in %s", want, calls)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	if n, err := config.ParseSize("512m"); err != nil || n != 512<<20 {
+		t.Errorf("ParseSize(512m) = %d, %v", n, err)
+	}
+	if _, err := config.ParseSize("lots"); err == nil {
+		t.Error("Expected an invalid size error")
+	}
+
+	sb, err := runner.ResolveSandbox(nil, 2)
+	if err != nil || sb.CPUs <= 0 || sb.Pids != runner.DefaultPidsLimit || sb.Network != "" {
+		t.Errorf("default sandbox = %+v, %v", sb, err)
+	}
+	cpus, pids := 1.5, int64(0)
+	sb, err = runner.ResolveSandbox(&config.SandboxConfig{Network: "none", CPUs: &cpus, Memory: "1g", Pids: &pids}, 4)
+	if err != nil || sb.CPUs != 1.5 || sb.Memory != 1<<30 || sb.Pids != 0 {
+		t.Errorf("configured sandbox = %+v, %v", sb, err)
+	}
+
+	// Only the install step runs online
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	eng, runs := fakeEngine(t)
+	task := planner.Task{Path: "web", Kind: "node", Version: "20", Entry: config.MatrixEntry{PackageManager: "npm"}}
+	opts := runner.Options{Logger: logging.New(true), WorkspaceRoot: root, Engine: eng, Sandbox: sb}
+	if _, err := runner.RunTask(context.Background(), task, opts, "npm", []string{"build", "lint"}); err != nil {
+		t.Fatalf("RunTask failed: %v", err)
+	}
+	calls := runs()
+	if len(calls) != 2 {
+		t.Fatalf("Expected an install and a build container, got %q", calls)
+	}
+	if strings.Contains(calls[0], "--network") || !strings.Contains(calls[0], "npm install") {
+		t.Errorf("Expected the install online: %s", calls[0])
+	}
+	if !strings.Contains(calls[1], "--network none") || !strings.Contains(calls[1], "::slick-autobuild-step::1'\n( npm run build") || strings.Contains(calls[1], "npm install") {
+		t.Errorf("Expected the build offline: %s", calls[1])
+	}
+	for _, call := range calls {
+		if !strings.Contains(call, "--cpus 1.5 --memory 1073741824") || strings.Contains(call, "--pids-limit") {
+			t.Errorf("Expected the resource limits: %s", call)
+		}
+	}
+
+	// corepack's shims are installed again in the offline container
+	task.Entry.PackageManager = "pnpm"
+	if _, err := runner.RunTask(context.Background(), task, opts, "pnpm", nil); err != nil {
+		t.Fatalf("RunTask failed: %v", err)
+	}
+	calls = runs()
+	if len(calls) != 2 || strings.Contains(calls[0], "--network") || !strings.Contains(calls[0], "corepack enable") ||
+		!strings.Contains(calls[1], "--network none") || !strings.Contains(calls[1], "bash -lc ( { corepack enable") {
+		t.Errorf("Expected corepack enabled in both containers: %q", calls)
+	}
+
+	// Go, Rust and Java fetch their dependencies online into cache volumes;
+	// Python builds stay online since they install their build backend
+	for kind, want := range map[string]string{"go": "download:online,build:offline", "rust": "fetch:online,build:offline",
+		"java": "fetch:online,build:offline", "python": "install:online,build:online"} {
+		tc, _ := toolchain.Lookup(kind)
+		var got []string
+		for _, st := range tc.(toolchain.Stepper).Steps(config.MatrixEntry{}, "1") {
+			got = append(got, st.Name+map[bool]string{true: ":online", false: ":offline"}[st.Network])
+		}
+		if strings.Join(got, ",") != want {
+			t.Errorf("Unexpected %s steps: %v", kind, got)
+		}
+		if _, ok := tc.(toolchain.VolumeProvider); !ok && kind != "python" {
+			t.Errorf("Expected %s to keep its dependencies in a cache volume", kind)
+		}
+	}
+
+	err = validateYAML(t, "runtime:\n  node:\n    versions: [\"20\"]\nmatrix:\n  - path: web\n    type: node\n    sandbox:\n      network: host\n      memory: 4x\n      pids: -1\n")
+	if err == nil || !strings.Contains(err.Error(), `unknown sandbox network "host"`) || !strings.Contains(err.Error(), `invalid size "4x"`) || !strings.Contains(err.Error(), "pids must not be negative") {
+		t.Errorf("Expected sandbox problems, got %v", err)
+	}
+}
+
+func TestImages(t *testing.T) {
+	task := planner.Task{Path: "web", Kind: "node", Version: "20", Entry: config.MatrixEntry{PackageManager: "npm"}}
+	if got := runner.Image(task, config.ImagesConfig{}, "npm", nil); got != "node:20" {
+		t.Errorf("default image = %s", got)
+	}
+	mirror := config.ImagesConfig{
+		Overrides:         map[string]string{"node": "registry.example.com:5000/mirror/node:{version}-slim"},
+		AllowedRegistries: []string{"registry.example.com:5000"},
+	}
+	image := runner.Image(task, mirror, "npm", nil)
+	if image != "registry.example.com:5000/mirror/node:20-slim" {
+		t.Errorf("override image = %s", image)
+	}
+	if err := runner.CheckRegistry(image, mirror.AllowedRegistries); err != nil {
+		t.Errorf("Expected the mirror allowed: %v", err)
+	}
+	if err := runner.CheckRegistry("node:20", mirror.AllowedRegistries); err == nil || !strings.Contains(err.Error(), "docker.io") {
+		t.Errorf("Expected docker.io rejected, got %v", err)
+	}
+
+	// A fake engine CLI that only has the image after a pull
+	bin := t.TempDir()
+	calls := filepath.Join(bin, "calls.log")
+	script := filepath.Join(bin, "podman")
+	err := os.WriteFile(script, []byte(`#!/bin/sh
+echo "$1" >> `+calls+`
+echo "$*" >> `+calls+`.args
+case "$1" in
+pull) touch `+bin+`/pulled ;;
+image) [ -f `+bin+`/pulled ] && echo '["docker.io/library/node@sha256:aaa","docker.io/library/node@sha256:bbb"]' || exit 1 ;;
+esac
+`), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eng := &engine.Engine{Name: engine.Podman, Binary: script}
+	resolve := func(ctx context.Context, ref string) (string, error) {
+		return docker.ImageDigest(ctx, eng, nil, ref, logging.New(true))
+	}
+
+	path := filepath.Join(t.TempDir(), runner.LockFile)
+	lock, err := runner.LoadImageLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, digest, err := lock.Pin(context.Background(), "node:20", false, resolve)
+	if err != nil || pinned != "node:20@sha256:aaa" || digest != "sha256:aaa" {
+		t.Fatalf("Pin = %s, %s, %v", pinned, digest, err)
+	}
+	if err := lock.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(calls)
+	if string(data) != "image\npull\nimage\n" {
+		t.Errorf("Expected inspect, pull, inspect; got %q", data)
+	}
+
+	// Later runs use the lock without asking the engine
+	os.Remove(calls)
+	lock, err = runner.LoadImageLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _, err = lock.Pin(context.Background(), "node:20", false, resolve); err != nil || pinned != "node:20@sha256:aaa" {
+		t.Errorf("locked Pin = %s, %v", pinned, err)
+	}
+	if _, err := os.Stat(calls); err == nil {
+		t.Error("Expected a locked image not to be resolved")
+	}
+	if _, _, err = lock.Pin(context.Background(), "node:20", true, resolve); err != nil {
+		t.Errorf("update Pin failed: %v", err)
+	}
+	if data, _ = os.ReadFile(calls); string(data) != "image\n" {
+		t.Errorf("Expected --update-lock to resolve again, got %q", data)
+	}
+	// Builds run the pinned image
+	os.Remove(calls)
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts := runner.Options{Logger: logging.New(true), WorkspaceRoot: root, Engine: eng, Image: "node:20@sha256:" + strings.Repeat("ab", 32)}
+	if _, err := runner.RunTask(context.Background(), task, opts, "npm", nil); err != nil {
+		t.Fatalf("RunTask with a pinned image failed: %v", err)
+	}
+	if data, _ = os.ReadFile(calls); string(data) != "run\n" {
+		t.Errorf("Expected a build container, got %q", data)
+	}
+	// Podman gets fully qualified build images
+	args, _ := os.ReadFile(calls + ".args")
+	if !strings.Contains(string(args), "image inspect --format {{json .RepoDigests}} docker.io/library/node:20\n") ||
+		!strings.Contains(string(args), " docker.io/library/node:20@sha256:abab") {
+		t.Errorf("Expected qualified image references, got %s", args)
+	}
+
+	if pinned, digest, _ = lock.Pin(context.Background(), "mirror/node@sha256:ccc", false, resolve); pinned != "mirror/node@sha256:ccc" || digest != "sha256:ccc" {
+		t.Errorf("digest Pin = %s, %s", pinned, digest)
+	}
+
+	err = validateYAML(t, "images:\n  overrides:\n    cobol: mirror/cobol:{version}\n  allowedRegistries: [ghcr.io/acme]\nmatrix:\n  - path: web\n    type: node\n")
+	if err == nil || !strings.Contains(err.Error(), `unknown toolchain "cobol"`) || !strings.Contains(err.Error(), `invalid registry "ghcr.io/acme"`) {
+		t.Errorf("Expected images problems, got %v", err)
+	}
+}